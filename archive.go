@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"quark/database"
+)
+
+// from_tar imports the tar archive at archive_path, - for stdin, into the
+// database at path, creating it if needed. Returns the exit code.
+func from_tar(archive_path string, path string) int {
+	archive := os.Stdin
+	// the passphrase can't be read from the archive
+	stdin_taken = archive_path == "-"
+	if archive_path != "-" {
+		file, err := os.Open(archive_path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "[FROMTAR]", err)
+			return 1
+		}
+		defer file.Close()
+		archive = file
+	}
+	db, err := open_database(filepath.Clean(path))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "[FROMTAR]", err)
+		return 1
+	}
+	defer db.Close()
+	names, err := db.ImportTar(archive)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "[FROMTAR] Imported %d files into %s\n", len(names), path)
+	return 0
+}
+
+// to_tar writes every file of the database at path to the tar archive at
+// archive_path, - for stdout. Returns the exit code.
+func to_tar(path string, archive_path string) int {
+	db, err := open_database(filepath.Clean(path))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "[TOTAR]", err)
+		return 1
+	}
+	defer db.Close()
+	if archive_path == "-" {
+		if err := db.ExportTar(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	archive, err := os.Create(archive_path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "[TOTAR]", err)
+		return 1
+	}
+	// a cut archive is not left behind
+	err = db.ExportTar(archive)
+	if close_err := archive.Close(); err == nil && close_err != nil {
+		err = fmt.Errorf("[TOTAR] Failed to write the archive: %w", close_err)
+	}
+	if err != nil {
+		os.Remove(archive_path)
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "[TOTAR] Exported %d files to %s\n", len(db.List()), archive_path)
+	return 0
+}
+
+// export_zip writes every file of db to the zip archive at archive_path
+func export_zip(db *database.DB, archive_path string) {
+	archive, err := os.Create(archive_path)
+	if err != nil {
+		fmt.Println("[EXPORT]", err)
+		return
+	}
+	// a cut archive is not left behind
+	err = db.ExportZip(archive)
+	if close_err := archive.Close(); err == nil && close_err != nil {
+		err = fmt.Errorf("[EXPORT] Failed to write the archive: %w", close_err)
+	}
+	if err != nil {
+		os.Remove(archive_path)
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("[EXPORT] Exported %d files to %s\n", len(db.List()), archive_path)
+}
+
+// import_zip adds the files of the zip archive at archive_path to db
+func import_zip(db *database.DB, archive_path string) {
+	archive, err := os.Open(archive_path)
+	if err != nil {
+		fmt.Println("[IMPORT]", err)
+		return
+	}
+	defer archive.Close()
+	stat, err := archive.Stat()
+	if err != nil {
+		fmt.Println("[IMPORT]", err)
+		return
+	}
+	names, err := db.ImportZip(archive, stat.Size())
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("[IMPORT] Imported %d files\n", len(names))
+}
@@ -0,0 +1,176 @@
+package database
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"sort"
+)
+
+// archive_entry is a file read from an archive being imported
+type archive_entry struct {
+	name        string
+	info        os.FileInfo
+	size        int64
+	compression Compression
+	body        io.ReadCloser
+}
+
+// layout returns the records in the order of their offsets, the order
+// their data is read front to back. Caller must hold db.lock.
+func (db *DB) layout() []Record {
+	records := make([]Record, len(db.db.Records))
+	copy(records, db.db.Records)
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Offset < records[j].Offset
+	})
+	return records
+}
+
+// import_entries streams the regular files next returns past the end of
+// the database under a journal_append, then updates the metadata in place
+// once next returns io.EOF. Returns the names imported.
+// Caller must hold db.lock.
+func (db *DB) import_entries(tag string, next func() (archive_entry, error)) ([]string, error) {
+	stat, err := db.file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("[%s] Can't read database: %w", tag, err)
+	}
+	size := stat.Size()
+	end := max(size, db.header.DataStart)
+	if err := db.begin(tag, journal{kind: journal_append, op: tag, size: size}); err != nil {
+		return nil, err
+	}
+	abort := func(err error) ([]string, error) {
+		if db.step("rollback") == nil && db.file.Truncate(size) == nil && db.file.Sync() == nil {
+			os.Remove(journal_path(db.path))
+		}
+		return nil, err
+	}
+
+	records := make([]Record, len(db.db.Records))
+	copy(records, db.db.Records)
+	var names []string
+	taken := make(map[string]bool)
+	for {
+		entry, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return abort(fmt.Errorf("[%s] Failed to read the archive: %w", tag, err))
+		}
+		// directories are implied by the names, links are not files
+		if !entry.info.Mode().IsRegular() {
+			entry.body.Close()
+			continue
+		}
+		name, err := normalize_name(path.Clean(entry.name))
+		if err == nil {
+			err = check_local(name)
+		}
+		if err != nil {
+			entry.body.Close()
+			return abort(fmt.Errorf("[%s] %w", tag, err))
+		}
+		if _, exists := db.find_record(name); exists || taken[name] {
+			entry.body.Close()
+			return abort(fmt.Errorf("[%s] %w: %s", tag, ErrExists, name))
+		}
+		taken[name] = true
+
+		record, err := db.stream_file(entry.body, entry.info, name, entry.size, entry.compression, end)
+		entry.body.Close()
+		if err != nil {
+			return abort(fmt.Errorf("[%s] Failed to write %s: %w", tag, name, err))
+		}
+		// data already stored is shared, the copy just written is
+		// overwritten by the next file
+		shared := db.share_data(&record)
+		for _, other := range records[len(db.db.Records):] {
+			if !shared && other.same_data(record) {
+				record.Offset, record.Stored, record.Compression, record.Nonce = other.Offset, other.Stored, other.Compression, other.Nonce
+				shared = true
+			}
+		}
+		if !shared {
+			end += record.Stored
+		}
+		records = append(records, record)
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return abort(nil)
+	}
+	if err := db.file.Truncate(end); err != nil {
+		return abort(fmt.Errorf("[%s] Failed to write the archive: %w", tag, err))
+	}
+
+	header := db.header
+	streamed := make([]Record, len(records))
+	copy(streamed, records)
+	// make room for the metadata, growing moves files to the end
+	if size := metadata_size(records, db.db.Deleted) + db.crypt.metadata_overhead(); db.metadata_start()+size > header.DataStart {
+		header.DataStart, _ = db.grow(records, size, end)
+	}
+	write_data := func() error { return db.move_records(streamed, records) }
+	if err := db.update_from(tag, "", size, true, header, records, db.db.Deleted, write_data); err != nil {
+		return nil, err
+	}
+	db.header = header
+	db.db = DatabaseStructure{
+		RecordCount: uint32(len(records)),
+		Records:     records,
+		Deleted:     db.db.Deleted,
+	}
+	return names, nil
+}
+
+// stream_file writes the file of size bytes read from r to the database
+// at offset at, compressed with c, and builds its record with the
+// attributes in info. Caller must hold db.lock.
+func (db *DB) stream_file(r io.Reader, info os.FileInfo, name string, size int64, c Compression, at int64) (Record, error) {
+	record := Record{FileName: name, Size: size, Offset: at, Compression: c}
+	var err error
+	if record.Nonce, err = db.crypt.new_nonce(); err != nil {
+		return record, err
+	}
+	// the start of the file is looked at for its content type
+	body := bufio.NewReaderSize(r, sniff_length)
+	head, _ := body.Peek(sniff_length)
+	record.set_info(info, head)
+
+	crc := crc32.New(crc_table)
+	sha := sha256.New()
+	counter := &count_writer{}
+	plain := io.TeeReader(body, io.MultiWriter(crc, sha, counter))
+	stored := db.crypt.seal_reader(compress_reader(plain, c), record.Nonce)
+	defer stored.Close()
+	if err := db.step("data:write"); err != nil {
+		return record, err
+	}
+	if record.Stored, err = io.Copy(io.NewOffsetWriter(db.file, at), stored); err != nil {
+		return record, err
+	}
+	// the body is read to its end, where archives check their own sums
+	if counter.n != size {
+		return record, fmt.Errorf("%w: archive gave %d bytes for %d", ErrChecksum, counter.n, size)
+	}
+	record.Checksum = crc.Sum32()
+	sha.Sum(record.Hash[:0])
+	return record, nil
+}
+
+// count_writer counts the bytes written to it
+type count_writer struct {
+	n int64
+}
+
+func (w *count_writer) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
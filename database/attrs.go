@@ -0,0 +1,67 @@
+package database
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// sniff_length is how much of a file content type detection looks at
+const sniff_length = 512
+
+// Attributes returns the modification time and permission bits recorded
+// for the file, ok is false for records written without them
+func (r Record) Attributes() (modified time.Time, mode os.FileMode, ok bool) {
+	if r.flags&record_attributes == 0 {
+		return time.Time{}, 0, false
+	}
+	return time.Unix(0, r.ModTime), os.FileMode(r.Mode) & os.ModePerm, true
+}
+
+// Restore applies the recorded permission bits and modification time to
+// the file at path, records without them leave it as it is
+func (r Record) Restore(path string) error {
+	modified, mode, ok := r.Attributes()
+	if !ok {
+		return nil
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		return err
+	}
+	return os.Chtimes(path, modified, modified)
+}
+
+// set_attributes records the modification time, permission bits and
+// content type of file, read from its start. The file is left at its
+// start.
+func (r *Record) set_attributes(file *os.File, info os.FileInfo) error {
+	if mime.TypeByExtension(filepath.Ext(info.Name())) != "" {
+		r.set_info(info, nil)
+		return nil
+	}
+	head := make([]byte, sniff_length)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	r.set_info(info, head[:n])
+	_, err = file.Seek(0, io.SeekStart)
+	return err
+}
+
+// set_info records the modification time and permission bits of info
+// and the content type of a file starting with head
+func (r *Record) set_info(info os.FileInfo, head []byte) {
+	r.ModTime = info.ModTime().UnixNano()
+	r.Mode = uint32(info.Mode().Perm())
+	r.flags |= record_attributes
+
+	// the extension is trusted first, the content only when it is unknown
+	r.ContentType = mime.TypeByExtension(filepath.Ext(info.Name()))
+	if r.ContentType == "" && len(head) > 0 {
+		r.ContentType = http.DetectContentType(head)
+	}
+}
@@ -0,0 +1,218 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// Problem is a piece of damage found by Check
+type Problem struct {
+	Kind   string `json:"kind"`             // header, key, metadata, name, duplicate, size, offset, compression, overlap, checksum, trailing or journal
+	Record string `json:"record,omitempty"` // name of the record it concerns
+	Detail string `json:"detail"`
+}
+
+// Report is the result of checking a database file
+type Report struct {
+	Path      string    `json:"path"`
+	Version   uint16    `json:"version"`
+	Encrypted bool      `json:"encrypted"`
+	Size      int64     `json:"size"`
+	DataStart int64     `json:"data_start"`
+	Records   int       `json:"records"`
+	Deleted   int       `json:"deleted"`
+	LiveBytes int64     `json:"live_bytes"` // as stored
+	DeadBytes int64     `json:"dead_bytes"`
+	Problems  []Problem `json:"problems"`
+}
+
+// OK reports whether no problems were found
+func (r Report) OK() bool {
+	return len(r.Problems) == 0
+}
+
+func (r *Report) add(kind string, record string, format string, args ...any) {
+	r.Problems = append(r.Problems, Problem{Kind: kind, Record: record, Detail: fmt.Sprintf(format, args...)})
+}
+
+// Check validates the database file at path without opening, migrating
+// or recovering it. The error is only set when the file can't be read,
+// damage is listed in the report. Encrypted databases need CheckEncrypted.
+func Check(path string) (Report, error) {
+	return check_path(path, nil)
+}
+
+// CheckEncrypted validates the encrypted database at path like Check,
+// with the key derived from passphrase
+func CheckEncrypted(path string, passphrase []byte) (Report, error) {
+	if passphrase == nil {
+		passphrase = []byte{}
+	}
+	return check_path(path, passphrase)
+}
+
+func check_path(path string, passphrase []byte) (Report, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Report{Path: path}, fmt.Errorf("[CHECK] Error opening database: %w", err)
+	}
+	defer file.Close()
+	return check_file(file, path, func(header Header) (*crypt, error) {
+		return header_crypt(header, passphrase)
+	})
+}
+
+// Check validates the open database like Check
+func (db *DB) Check() (Report, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	if db.file == nil {
+		return Report{Path: db.path}, ErrClosed
+	}
+	return check_file(db.file, db.path, func(Header) (*crypt, error) {
+		return db.crypt, nil
+	})
+}
+
+// check_file validates the header, records and data region of file,
+// read through ReadAt so the position of file is not used. key returns
+// the crypt of the header read.
+func check_file(file *os.File, path string, key func(Header) (*crypt, error)) (Report, error) {
+	report := Report{Path: path, Problems: []Problem{}}
+	stat, err := file.Stat()
+	if err != nil {
+		return report, fmt.Errorf("[CHECK] Can't read database: %w", err)
+	}
+	report.Size = stat.Size()
+	if _, err := os.Stat(journal_path(path)); err == nil {
+		report.add("journal", "", "an interrupted operation is pending, opening the database finishes it")
+	}
+
+	reader := io.NewSectionReader(file, 0, report.Size)
+	header, err := read_header(reader)
+	if errors.Is(err, ErrNotQuark) {
+		version0, v0err := is_version0(reader, report.Size)
+		if v0err != nil {
+			return report, fmt.Errorf("[CHECK] Can't read database: %w", v0err)
+		}
+		if !version0 {
+			report.add("header", "", "%s", err)
+			return report, nil
+		}
+		header, err = Header{Version: 0}, nil
+	}
+	if err != nil {
+		report.add("header", "", "%s", err)
+		return report, nil
+	}
+	report.Version = header.Version
+	report.Encrypted = header.Encryption != EncryptNone
+	c, err := key(header)
+	if err != nil {
+		report.add("key", "", "%s", err)
+		return report, nil
+	}
+
+	var structure DatabaseStructure
+	if err := read_structure(reader, &header, &structure, c); err != nil {
+		report.add("metadata", "", "%s", err)
+		return report, nil
+	}
+	report.DataStart = header.DataStart
+	report.Records = len(structure.Records)
+	report.Deleted = len(structure.Deleted)
+	// before version 6 the data starts right after the records
+	metadata_end := int64(header.HeaderLength) + metadata_size(structure.Records, structure.Deleted) + c.metadata_overhead()
+	if header.Version >= 6 && header.DataStart < metadata_end {
+		report.add("metadata", "", "records end at %d, past the data start %d", metadata_end, header.DataStart)
+	}
+	if header.DataStart > report.Size {
+		report.add("metadata", "", "data start %d is past the end of the file %d", header.DataStart, report.Size)
+	}
+
+	// names
+	seen := make(map[string]bool)
+	for _, record := range structure.Records {
+		name, err := normalize_name(record.FileName)
+		if err != nil {
+			report.add("name", record.FileName, "%s", err)
+		} else if header.Version >= 3 && name != record.FileName {
+			report.add("name", record.FileName, "name is not normalized, expected %q", name)
+		}
+		if seen[record.FileName] {
+			report.add("duplicate", record.FileName, "name is stored more than once")
+		}
+		seen[record.FileName] = true
+	}
+
+	// extents and checksums
+	end := header.DataStart
+	for _, list := range [][]Record{structure.Records, structure.Deleted} {
+		for _, record := range list {
+			if record.Stored >= 0 && record.Offset+record.Stored > end && record.Offset+record.Stored <= report.Size {
+				end = record.Offset + record.Stored
+			}
+		}
+	}
+	live := make([]Record, 0, len(structure.Records))
+	for _, record := range structure.Records {
+		if record.Size < 0 || record.Stored < 0 {
+			report.add("size", record.FileName, "negative size %d, stored %d", record.Size, record.Stored)
+			continue
+		}
+		if record.Offset < header.DataStart || record.Offset+record.Stored > report.Size {
+			report.add("offset", record.FileName, "data at %d+%d is outside the data region %d-%d", record.Offset, record.Stored, header.DataStart, report.Size)
+			continue
+		}
+		if !shares_extent(live, record) {
+			report.LiveBytes += record.Stored
+		}
+		live = append(live, record)
+		if !record.Compression.valid() {
+			report.add("compression", record.FileName, "unknown compression %d", record.Compression)
+			continue
+		}
+		if header.Version < 4 {
+			continue // no checksums yet
+		}
+		if err := verify_stored(io.NewSectionReader(file, record.Offset, record.Stored), record, c); err != nil {
+			report.add("checksum", record.FileName, "%s", err)
+		}
+	}
+	sort.Slice(live, func(i, j int) bool {
+		if live[i].Offset != live[j].Offset {
+			return live[i].Offset < live[j].Offset
+		}
+		return live[i].Stored < live[j].Stored
+	})
+	for ix := 1; ix < len(live); ix++ {
+		previous := live[ix-1]
+		if previous.Offset == live[ix].Offset && previous.Stored == live[ix].Stored && previous.same_data(live[ix]) {
+			continue // identical files share their data
+		}
+		if previous.Offset+previous.Stored > live[ix].Offset {
+			report.add("overlap", live[ix].FileName, "data at %d overlaps %s ending at %d", live[ix].Offset, previous.FileName, previous.Offset+previous.Stored)
+		}
+	}
+	if data := end - header.DataStart; data > report.LiveBytes {
+		report.DeadBytes = data - report.LiveBytes
+	}
+	if end < report.Size && header.DataStart <= report.Size {
+		report.add("trailing", "", "%d bytes after the last record at %d", report.Size-end, end)
+	}
+	return report, nil
+}
+
+// shares_extent reports whether a record in live stores the same data as
+// record at the same place
+func shares_extent(live []Record, record Record) bool {
+	for _, other := range live {
+		if other.Offset == record.Offset && other.Stored == record.Stored && other.same_data(record) {
+			return true
+		}
+	}
+	return false
+}
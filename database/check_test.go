@@ -0,0 +1,65 @@
+package database_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"quark/database"
+	"testing"
+)
+
+// check_clean fails t if Check finds problems in db
+func check_clean(t *testing.T, db *database.DB) {
+	t.Helper()
+	report, err := db.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, problem := range report.Problems {
+		t.Errorf("check: %s %s: %s", problem.Kind, problem.Record, problem.Detail)
+	}
+}
+
+// TestCheckAfterReplace replaces the last file of the database with a
+// copy of another and then twice with new data. Its old data is dead and
+// accounted for, not trailing garbage, unless another file still shares
+// it.
+func TestCheckAfterReplace(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	db, err := database.Open(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, path := range []string{write("a", "aaaaaaaaaa"), write("b", "bbbbbbbbbbbbbbbbbbbbbbb")} {
+		if err := db.Put(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	replacements := []string{"aaaaaaaaaa", "a new b, sharing nothing", "the newest b"}
+	for _, replacement := range replacements {
+		if err := db.Replace("b", write("replacement", replacement)); err != nil {
+			t.Fatal(err)
+		}
+		check_clean(t, db)
+		var got bytes.Buffer
+		if err := db.Get("b", &got); err != nil || got.String() != replacement {
+			t.Fatalf("b reads %q, %v", got.String(), err)
+		}
+	}
+	usage, err := db.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dead := int64(len("bbbbbbbbbbbbbbbbbbbbbbb") + len(replacements[1])); usage.DeadBytes != dead {
+		t.Errorf("%d dead bytes, want %d", usage.DeadBytes, dead)
+	}
+}
@@ -0,0 +1,131 @@
+package database
+
+import (
+	"compress/flate"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Compression is how the data of a record is stored
+type Compression uint8
+
+const (
+	CompressNone  Compression = iota // raw bytes
+	CompressFlate                    // compress/flate at the default level
+)
+
+var ErrCompression = errors.New("unknown compression")
+
+// String returns the name used by ParseCompression
+func (c Compression) String() string {
+	switch c {
+	case CompressNone:
+		return "none"
+	case CompressFlate:
+		return "flate"
+	}
+	return fmt.Sprintf("compression(%d)", uint8(c))
+}
+
+// ParseCompression returns the compression called name
+func ParseCompression(name string) (Compression, error) {
+	switch name {
+	case "none":
+		return CompressNone, nil
+	case "flate":
+		return CompressFlate, nil
+	}
+	return CompressNone, fmt.Errorf("%w: %s", ErrCompression, name)
+}
+
+func (c Compression) valid() bool {
+	return c <= CompressFlate
+}
+
+// compress_reader returns the stored form of everything in r. The
+// output of flate is the same for the same input, so the stored size
+// measured once holds when the data is compressed again.
+func compress_reader(r io.Reader, c Compression) io.ReadCloser {
+	if c == CompressNone {
+		return io.NopCloser(r)
+	}
+	reader, writer := io.Pipe()
+	go func() {
+		compressor, _ := flate.NewWriter(writer, flate.DefaultCompression)
+		_, err := io.Copy(compressor, r)
+		if err == nil {
+			err = compressor.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+	return reader
+}
+
+// decompress_reader returns the file data of record stored in r
+func decompress_reader(r io.Reader, record Record) (io.ReadCloser, error) {
+	switch record.Compression {
+	case CompressNone:
+		return io.NopCloser(r), nil
+	case CompressFlate:
+		return flate.NewReader(r), nil
+	}
+	return nil, fmt.Errorf("%w: %s uses %d", ErrCompression, record.FileName, record.Compression)
+}
+
+// measure_source returns the checksum and hash of everything left in r
+// and its size once stored with c
+func measure_source(r io.Reader, c Compression) (sum uint32, hash [32]byte, stored int64, err error) {
+	crc := crc32.New(crc_table)
+	sha := sha256.New()
+	compressed := compress_reader(io.TeeReader(r, io.MultiWriter(crc, sha)), c)
+	defer compressed.Close()
+	stored, err = io.Copy(io.Discard, compressed)
+	sha.Sum(hash[:0])
+	return crc.Sum32(), hash, stored, err
+}
+
+// hash_stored returns the hash of the file data of record stored in r
+func hash_stored(r io.Reader, record Record) (hash [32]byte, err error) {
+	data, err := decompress_reader(r, record)
+	if err != nil {
+		return hash, err
+	}
+	defer data.Close()
+	sha := sha256.New()
+	if _, err := io.Copy(sha, data); err != nil {
+		return hash, fmt.Errorf("Error decompressing %s: %w", record.FileName, err)
+	}
+	sha.Sum(hash[:0])
+	return hash, nil
+}
+
+// verify_stored unseals and decompresses the stored data of record in r
+// and checks its size, checksum and hash
+func verify_stored(r io.Reader, record Record, c *crypt) error {
+	data, err := decompress_reader(c.open_reader(r, record), record)
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+	crc := crc32.New(crc_table)
+	sha := sha256.New()
+	n, err := io.Copy(io.MultiWriter(crc, sha), data)
+	if err != nil {
+		return fmt.Errorf("Error decompressing %s: %w", record.FileName, err)
+	}
+	if n != record.Size {
+		return fmt.Errorf("%w: %s (size %d, read %d)", ErrChecksum, record.FileName, record.Size, n)
+	}
+	if err := verify_checksum(record, crc.Sum32()); err != nil {
+		return err
+	}
+	// records from before version 9 have no hash
+	var hash [32]byte
+	if sha.Sum(hash[:0]); record.Hash != [32]byte{} && hash != record.Hash {
+		return fmt.Errorf("%w: %s (hash differs)", ErrChecksum, record.FileName)
+	}
+	return nil
+}
@@ -0,0 +1,693 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// layout_entry is a record placed by rewrite. Entries without a source
+// are copied from their current offset in the database.
+type layout_entry struct {
+	record Record
+	source io.Reader
+}
+
+// open_source opens the file at filepath to be written with compression
+// c and builds its record. The returned file is positioned at its start.
+// A file of the same name must be stored already when replacing, and
+// must not be otherwise.
+func (db *DB) open_source(tag string, filepath string, c Compression, replacing bool) (*os.File, Record, error) {
+	return db.open_named(tag, filepath, "", c, replacing)
+}
+
+// open_named is open_source storing the file under name, its base name
+// if name is empty
+func (db *DB) open_named(tag string, filepath string, name string, c Compression, replacing bool) (*os.File, Record, error) {
+	var record Record
+	// open file
+	new_file, err := os.Open(filepath)
+	if err != nil {
+		return nil, record, fmt.Errorf("[%s] Error opening source file: %w", tag, err)
+	}
+
+	fileInfo, err := new_file.Stat()
+	if err != nil {
+		new_file.Close()
+		return nil, record, fmt.Errorf("[%s] Can't read file: %w", tag, err)
+	}
+	if name == "" {
+		name = fileInfo.Name()
+	}
+	file_name, err := normalize_name(name)
+	if err != nil {
+		new_file.Close()
+		return nil, record, fmt.Errorf("[%s] %w", tag, err)
+	}
+	if _, exists := db.find_record(file_name); exists && !replacing {
+		new_file.Close()
+		return nil, record, fmt.Errorf("[%s] %w: %s", tag, ErrExists, file_name)
+	} else if !exists && replacing {
+		new_file.Close()
+		return nil, record, fmt.Errorf("[%s] %w: %s", tag, ErrNotFound, file_name)
+	}
+	if !c.valid() {
+		new_file.Close()
+		return nil, record, fmt.Errorf("[%s] %w: %d", tag, ErrCompression, c)
+	}
+	// Create Record
+	record.FileName = file_name
+	record.Size = fileInfo.Size()
+	record.Compression = c
+	if err := record.set_attributes(new_file, fileInfo); err != nil {
+		new_file.Close()
+		return nil, record, fmt.Errorf("[%s] Can't read file: %w", tag, err)
+	}
+	record.Checksum, record.Hash, record.Stored, err = measure_source(new_file, c)
+	if err == nil && c == CompressNone && record.Stored != record.Size {
+		err = fmt.Errorf("size changed while reading")
+	}
+	record.Stored = db.crypt.sealed_size(record.Stored)
+	if err == nil {
+		record.Nonce, err = db.crypt.new_nonce()
+	}
+	if err == nil {
+		_, err = new_file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		new_file.Close()
+		return nil, record, fmt.Errorf("[%s] Can't read file: %w", tag, err)
+	}
+	return new_file, record, nil
+}
+
+// share_data points record at the data of a live record holding the same
+// file, if there is one. Reports whether it found one.
+func (db *DB) share_data(record *Record) bool {
+	for _, other := range db.db.Records {
+		if other.same_data(*record) {
+			record.Offset, record.Stored, record.Compression, record.Nonce = other.Offset, other.Stored, other.Compression, other.Nonce
+			return true
+		}
+	}
+	return false
+}
+
+// references counts the live records sharing the data of record.
+// Counts are not stored, they follow from the hashes.
+func (db *DB) references(record Record) int {
+	count := 0
+	for _, other := range db.db.Records {
+		if other.Offset == record.Offset && other.same_data(record) {
+			count++
+		}
+	}
+	return count
+}
+
+// append writes the file at filepath compressed with c after the last
+// byte of the database and updates the metadata in place, the rest of
+// the data is not touched. A file already stored under another name is
+// not written again, the record shares its data.
+// Caller must hold db.lock exclusively.
+func (db *DB) append(filepath string, c Compression) error {
+	new_file, record, err := db.open_source("WRITE", filepath, c, false)
+	if err != nil {
+		return err
+	}
+	defer new_file.Close()
+	shared := db.share_data(&record)
+
+	end, err := db.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("[WRITE] Error seeking end of database: %w", err)
+	}
+	if end < db.header.DataStart {
+		end = db.header.DataStart
+	}
+
+	records := make([]Record, len(db.db.Records), len(db.db.Records)+1)
+	copy(records, db.db.Records)
+	records = append(records, record)
+	header := db.header
+	// make room for the metadata first, growing moves files to the end
+	if size := metadata_size(records, db.db.Deleted) + db.crypt.metadata_overhead(); db.metadata_start()+size > header.DataStart {
+		header.DataStart, end = db.grow(records, size, end)
+	}
+	if !shared {
+		records[len(records)-1].Offset = end
+	}
+
+	write_data := func() error {
+		if err := db.move_grown(records); err != nil {
+			return err
+		}
+		if shared {
+			return nil
+		}
+		if err := db.step("data:write"); err != nil {
+			return fmt.Errorf("[WRITE] %w", err)
+		}
+		stored := db.crypt.seal_reader(compress_reader(new_file, c), record.Nonce)
+		defer stored.Close()
+		if _, err := io.CopyN(io.NewOffsetWriter(db.file, end), stored, record.Stored); err != nil {
+			return fmt.Errorf("[WRITE] Failed to write the new file: %w", err)
+		}
+		return nil
+	}
+	if err := db.update("WRITE", record.FileName, header, records, db.db.Deleted, write_data); err != nil {
+		return err
+	}
+	db.header = header
+	db.db = DatabaseStructure{
+		RecordCount: uint32(len(records)),
+		Records:     records,
+		Deleted:     db.db.Deleted,
+	}
+	return nil
+}
+
+// grow finds a data start that leaves room for metadata of the given
+// size. Files stored in the way get new offsets from end in records, the
+// caller moves them. Returns the new data start and end of the database.
+// Caller must hold db.lock.
+func (db *DB) grow(records []Record, size int64, end int64) (data_start int64, new_end int64) {
+	data_start = db.metadata_start() + metadata_capacity(size)
+	if end < data_start {
+		end = data_start
+	}
+	// shared data moves once, an empty file shares its offset with the
+	// file after it but not its extent
+	moved := make(map[[2]int64]int64)
+	for ix := range records {
+		if records[ix].Offset >= data_start || records[ix].Offset < db.header.DataStart {
+			continue
+		}
+		extent := [2]int64{records[ix].Offset, records[ix].Stored}
+		if to, ok := moved[extent]; ok {
+			records[ix].Offset = to
+			continue
+		}
+		moved[extent] = end
+		records[ix].Offset = end
+		end += records[ix].Stored
+	}
+	return data_start, end
+}
+
+// move_grown copies the data of the current records to the offsets grow
+// gave them in records, which holds them first in the same order.
+// Caller must hold db.lock.
+func (db *DB) move_grown(records []Record) error {
+	return db.move_records(db.db.Records, records)
+}
+
+// move_records copies the data of the records in from to the offsets
+// grow gave them in records, which holds them first in the same order.
+// Caller must hold db.lock.
+func (db *DB) move_records(from []Record, records []Record) error {
+	moved := make(map[[2]int64]bool)
+	for ix, old := range from {
+		extent := [2]int64{records[ix].Offset, old.Stored}
+		if records[ix].Offset == old.Offset || moved[extent] {
+			continue
+		}
+		moved[extent] = true
+		if err := db.step("grow:move"); err != nil {
+			return fmt.Errorf("[GROW] %w", err)
+		}
+		source := io.NewSectionReader(db.file, old.Offset, old.Stored)
+		if _, err := io.Copy(io.NewOffsetWriter(db.file, records[ix].Offset), source); err != nil {
+			return fmt.Errorf("[GROW] Failed to move %s: %w", old.FileName, err)
+		}
+	}
+	return nil
+}
+
+// write inserts the file at filepath compressed with c into the database
+// at order, every file after it is moved so this rewrites the whole
+// database. Caller must hold db.lock exclusively.
+func (db *DB) write(filepath string, order uint32, c Compression) (err error) {
+	if order > db.db.RecordCount {
+		return fmt.Errorf("[WRITE] %w: %d", ErrOrder, order)
+	}
+	new_file, record, err := db.open_source("WRITE", filepath, c, false)
+	if err != nil {
+		return err
+	}
+	defer new_file.Close()
+	// a file already stored is copied from the database like the others
+	var source io.Reader
+	if !db.share_data(&record) {
+		stored := db.crypt.seal_reader(compress_reader(new_file, c), record.Nonce)
+		defer stored.Close()
+		source = stored
+	}
+
+	//	place the new record at its order
+	entries := make([]layout_entry, 0, len(db.db.Records)+1)
+	for _, old := range db.db.Records[:order] {
+		entries = append(entries, layout_entry{record: old})
+	}
+	entries = append(entries, layout_entry{record: record, source: source})
+	for _, old := range db.db.Records[order:] {
+		entries = append(entries, layout_entry{record: old})
+	}
+	return db.rewrite("WRITE", record.FileName, entries)
+}
+
+// read copies the file stored under filename into dst, serving what it
+// can from the prefetch buffers. Caller must hold db.lock.
+func (db *DB) read(filename string, dst io.Writer) error {
+	// fail if we didn't write any files yet
+	if db.db.RecordCount == 0 {
+		return fmt.Errorf("[READ] %w", ErrEmpty)
+	}
+	index, ok := db.find_record(filename)
+	if !ok {
+		return fmt.Errorf("[READ] %w: %s", ErrNotFound, filename)
+	}
+	record := db.db.Records[index]
+	file_size := record.Stored
+	location := record.Offset
+
+	// the stored bytes come from the prefetch buffer first, the rest
+	// from the database
+	stored := db.stored_range(location, file_size)
+	if buff, ok := db.buffered(filename); ok {
+		reader := bytes.NewReader(buff)
+		if int64(reader.Len()) == file_size {
+			db.count_read(true)
+			stored = reader
+		} else { // continue queue read in cold read
+			db.count_read(false)
+			relen := reader.Size()
+			stored = io.MultiReader(reader, db.stored_range(location+relen, file_size-relen))
+		}
+	} else {
+		db.count_read(false)
+	}
+
+	// everything sent to dst is checksummed, a mismatch is reported
+	// after the last byte since the data is streamed
+	crc := crc32.New(crc_table)
+	dst = io.MultiWriter(dst, crc)
+	data, err := decompress_reader(db.crypt.open_reader(stored, record), record)
+	if err != nil {
+		return fmt.Errorf("[READ] %w", err)
+	}
+	defer data.Close()
+
+	// read and write to custom writer interface
+	n, err := io.Copy(dst, data)
+	if err != nil {
+		return fmt.Errorf("[READ] Failed reading file: %w", err)
+	}
+	if n != record.Size {
+		return fmt.Errorf("[READ] %w: %s (size %d, read %d)", ErrChecksum, record.FileName, record.Size, n)
+	}
+	if err := verify_checksum(record, crc.Sum32()); err != nil {
+		return fmt.Errorf("[READ] %w", err)
+	}
+	return nil
+}
+
+// overwrite_limit is the largest stored size replaced in place. The new
+// data is held in memory and journaled before it is written, larger
+// files are appended instead.
+const overwrite_limit = 1 << 20
+
+// core_replace stores the file at filepath over the stored file named
+// filename, the base name of filepath if it is empty, keeping its order
+// and compression. New data of the same stored size up to overwrite_limit
+// is written over the old when no other file shares it, otherwise it is
+// appended and the old data is dead. Caller must hold db.lock.
+func (db *DB) core_replace(filename string, filepath string) error {
+	if filename == "" {
+		info, err := os.Stat(filepath)
+		if err != nil {
+			return fmt.Errorf("[REPLACE] Error opening source file: %w", err)
+		}
+		filename = info.Name()
+	}
+	filename, err := normalize_name(filename)
+	if err != nil {
+		return fmt.Errorf("[REPLACE] %w", err)
+	}
+	index, ok := db.find_record(filename)
+	if !ok {
+		return fmt.Errorf("[REPLACE] %w: %s", ErrNotFound, filename)
+	}
+	old := db.db.Records[index]
+	new_file, record, err := db.open_named("REPLACE", filepath, filename, old.Compression, true)
+	if err != nil {
+		return err
+	}
+	defer new_file.Close()
+
+	records := make([]Record, len(db.db.Records))
+	copy(records, db.db.Records)
+	no_data := func() error { return nil }
+	stored := db.crypt.seal_reader(compress_reader(new_file, record.Compression), record.Nonce)
+	defer stored.Close()
+
+	// unchanged data shares the old data, only the attributes change
+	shared := db.share_data(&record)
+	records[index] = record
+	in_place := !shared && record.Stored == old.Stored && record.Stored <= overwrite_limit && db.references(old) == 1
+	// old data nothing else shares is dead unless it is kept or
+	// overwritten, a tombstone accounts for it like core_delete's
+	deleted := db.db.Deleted
+	if db.references(old) == 1 && !in_place && !(shared && record.Offset == old.Offset) {
+		tombstone := old
+		tombstone.flags |= record_deleted
+		deleted = make([]Record, len(db.db.Deleted), len(db.db.Deleted)+1)
+		copy(deleted, db.db.Deleted)
+		deleted = append(deleted, tombstone)
+	}
+	commit := func() error {
+		db.db.Records = records
+		db.db.Deleted = deleted
+		delete(db.file_buffer_map, filename)
+		return nil
+	}
+	if size := metadata_size(records, deleted) + db.crypt.metadata_overhead(); db.metadata_start()+size > db.header.DataStart {
+		// a longer content type left no room, which is rare enough
+		// to rebuild the database instead of growing in place
+		entries := make([]layout_entry, len(records))
+		for ix, other := range db.db.Records {
+			entries[ix] = layout_entry{record: other}
+		}
+		entries[index] = layout_entry{record: record}
+		if !shared {
+			entries[index].source = stored
+		}
+		if err := db.rewrite("REPLACE", filename, entries); err != nil {
+			return err
+		}
+		delete(db.file_buffer_map, filename)
+		return nil
+	}
+	if shared {
+		if err := db.update("REPLACE", filename, db.header, records, deleted, no_data); err != nil {
+			return err
+		}
+		return commit()
+	}
+	if in_place {
+		data := make([]byte, record.Stored)
+		if _, err := io.ReadFull(stored, data); err != nil {
+			return fmt.Errorf("[REPLACE] Can't read file: %w", err)
+		}
+		record.Offset = old.Offset
+		records[index] = record
+		if err := db.overwrite("REPLACE", filename, db.header, records, db.db.Deleted, old.Offset, data); err != nil {
+			return err
+		}
+		return commit()
+	}
+
+	end, err := db.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("[REPLACE] Error seeking end of database: %w", err)
+	}
+	record.Offset = max(end, db.header.DataStart)
+	records[index] = record
+	write_data := func() error {
+		if err := db.step("data:write"); err != nil {
+			return fmt.Errorf("[REPLACE] %w", err)
+		}
+		if _, err := io.CopyN(io.NewOffsetWriter(db.file, record.Offset), stored, record.Stored); err != nil {
+			return fmt.Errorf("[REPLACE] Failed to write the new file: %w", err)
+		}
+		return nil
+	}
+	if err := db.update("REPLACE", filename, db.header, records, deleted, write_data); err != nil {
+		return err
+	}
+	return commit()
+}
+
+// core_rename stores the file named old_name as new_name, only the
+// metadata changes unless it has to grow. Prefetch buffers, the queue
+// and the read log follow the new name. Caller must hold db.lock.
+func (db *DB) core_rename(old_name string, new_name string) error {
+	index, ok := db.find_record(old_name)
+	if !ok {
+		return fmt.Errorf("[RENAME] %w: %s", ErrNotFound, old_name)
+	}
+	if old_name == new_name {
+		return nil
+	}
+	if _, exists := db.find_record(new_name); exists {
+		return fmt.Errorf("[RENAME] %w: %s", ErrExists, new_name)
+	}
+
+	end, err := db.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("[RENAME] Error seeking end of database: %w", err)
+	}
+	records := make([]Record, len(db.db.Records))
+	copy(records, db.db.Records)
+	records[index].FileName = new_name
+	header := db.header
+	// a longer name may not fit, growing moves files to the end
+	if size := metadata_size(records, db.db.Deleted) + db.crypt.metadata_overhead(); db.metadata_start()+size > header.DataStart {
+		header.DataStart, _ = db.grow(records, size, end)
+	}
+	write_data := func() error { return db.move_grown(records) }
+	if err := db.update("RENAME", old_name, header, records, db.db.Deleted, write_data); err != nil {
+		return err
+	}
+	db.header = header
+	db.db.Records = records
+
+	if buff, ok := db.file_buffer_map[old_name]; ok {
+		delete(db.file_buffer_map, old_name)
+		db.file_buffer_map[new_name] = buff
+	}
+	for ix := range db.idle_queue.items {
+		if db.idle_queue.items[ix].FileName == old_name {
+			db.idle_queue.items[ix].FileName = new_name
+		}
+	}
+	for ix := range db.last_fileinfo {
+		if db.last_fileinfo[ix].Fname == old_name {
+			db.last_fileinfo[ix].Fname = new_name
+		}
+		for jx, edge := range db.last_fileinfo[ix].Info.MaxEdges {
+			if edge == old_name {
+				db.last_fileinfo[ix].Info.MaxEdges[jx] = new_name
+			}
+		}
+	}
+	if err := db.rename_readlog(old_name, new_name); err != nil {
+		return fmt.Errorf("[RENAME] Renamed, but the read log still has the old name: %w", err)
+	}
+	return nil
+}
+
+// core_delete marks the file stored under filename as deleted, its data
+// stays in place until the database is compacted. Data shared with other
+// files stays live, the record is dropped without a tombstone.
+// Caller must hold db.lock exclusively.
+func (db *DB) core_delete(filename string) error {
+	// check if database has any file
+	if db.db.RecordCount == 0 {
+		return fmt.Errorf("[DELETE] %w", ErrEmpty)
+	}
+	order, ok := db.find_record(filename)
+	if !ok {
+		return fmt.Errorf("[DELETE] %w: %s", ErrNotFound, filename)
+	}
+
+	records := make([]Record, 0, len(db.db.Records)-1)
+	records = append(records, db.db.Records[:order]...)
+	records = append(records, db.db.Records[order+1:]...)
+	deleted := make([]Record, len(db.db.Deleted), len(db.db.Deleted)+1)
+	copy(deleted, db.db.Deleted)
+	if db.references(db.db.Records[order]) == 1 {
+		tombstone := db.db.Records[order]
+		tombstone.flags |= record_deleted
+		deleted = append(deleted, tombstone)
+	}
+
+	// the tombstone takes as much room as the record, the metadata fits
+	if err := db.update("DELETE", filename, db.header, records, deleted, func() error { return nil }); err != nil {
+		return err
+	}
+	db.db = DatabaseStructure{
+		RecordCount: uint32(len(records)),
+		Records:     records,
+		Deleted:     deleted,
+	}
+	delete(db.file_buffer_map, filename)
+	return nil
+}
+
+// set_compression stores c as the default compression in the header.
+// Caller must hold db.lock.
+func (db *DB) set_compression(c Compression) error {
+	if !c.valid() {
+		return fmt.Errorf("[COMPRESSION] %w: %d", ErrCompression, c)
+	}
+	header := db.header
+	header.Compression = c
+	if err := db.update("COMPRESSION", "", header, db.db.Records, db.db.Deleted, func() error { return nil }); err != nil {
+		return err
+	}
+	db.header = header
+	return nil
+}
+
+// compact rewrites the database keeping only the live files in their
+// current order. Caller must hold db.lock exclusively.
+func (db *DB) compact() error {
+	entries := make([]layout_entry, 0, len(db.db.Records))
+	for _, record := range db.db.Records {
+		entries = append(entries, layout_entry{record: record})
+	}
+	return db.rewrite("COMPACT", "", entries)
+}
+
+// usage measures the database file against its live data.
+// Caller must hold db.lock.
+func (db *DB) usage() (Usage, error) {
+	stat, err := db.file.Stat()
+	if err != nil {
+		return Usage{}, fmt.Errorf("[STAT] Can't read database: %w", err)
+	}
+	usage := Usage{Files: len(db.db.Records), Size: stat.Size()}
+	counted := make(map[[2]int64]bool)
+	for _, record := range db.db.Records {
+		usage.LogicalBytes += record.Size
+		extent := [2]int64{record.Offset, record.Stored}
+		if counted[extent] {
+			usage.SharedBytes += record.Stored
+			continue
+		}
+		counted[extent] = true
+		usage.LiveBytes += record.Stored
+	}
+	if data := usage.Size - db.header.DataStart; data > usage.LiveBytes {
+		usage.DeadBytes = data - usage.LiveBytes
+	}
+	return usage, nil
+}
+
+// reorg rewrites the database with its files in the order of new_rec.
+// Caller must hold db.lock exclusively.
+func (db *DB) reorg(new_rec []string) error {
+	// TODO: check if structure is same as before
+	entries := make([]layout_entry, 0, len(new_rec))
+	for _, n_filename := range new_rec {
+		index, ok := db.find_record(n_filename)
+		if !ok {
+			return fmt.Errorf("[REORG] %w: %s", ErrNotFound, n_filename)
+		}
+		entries = append(entries, layout_entry{record: db.db.Records[index]})
+	}
+	return db.rewrite("REORG", "", entries)
+}
+
+// rewrite builds a new database holding entries in the given order, with
+// their data stored back to back after the room kept for the metadata,
+// and replaces the current database with it. Tombstones are dropped and
+// identical files are stored once, at the first of them.
+// name is the file the operation is applied to, if any.
+// Caller must hold db.lock.
+func (db *DB) rewrite(tag string, name string, entries []layout_entry) error {
+	records := make([]Record, len(entries))
+	for ix, entry := range entries {
+		records[ix] = entry.record
+	}
+	header := db.header
+	header.DataStart = db.metadata_start() + metadata_capacity(metadata_size(records)+db.crypt.metadata_overhead())
+	location := header.DataStart
+	placed := make(map[[32]byte]int)
+	shared := make([]bool, len(records))
+	for ix := range records {
+		if first, ok := placed[records[ix].Hash]; ok && records[first].same_data(records[ix]) {
+			records[ix].Offset, records[ix].Stored, records[ix].Compression, records[ix].Nonce = records[first].Offset, records[first].Stored, records[first].Compression, records[first].Nonce
+			shared[ix] = true
+			continue
+		}
+		if _, ok := placed[records[ix].Hash]; !ok {
+			placed[records[ix].Hash] = ix
+		}
+		records[ix].Offset = location
+		location += records[ix].Stored
+	}
+
+	// the journal holds the new metadata so recovery can tell whether
+	// the rewrite file replaced the database
+	metadata, err := encode_metadata(header, records, nil, db.crypt)
+	if err != nil {
+		return fmt.Errorf("[%s] Failed to encode the metadata: %w", tag, err)
+	}
+
+	// Build the new database in the rewrite file next to the database,
+	// it replaces the database once complete
+	if err := db.begin(tag, journal{kind: journal_rewrite, op: tag, name: name, metadata: metadata}); err != nil {
+		return err
+	}
+	abort := func(err error) error {
+		if db.step("rollback") == nil && os.Remove(rewrite_path(db.path)) == nil {
+			os.Remove(journal_path(db.path))
+		}
+		return err
+	}
+	if err := db.step("rewrite:create"); err != nil {
+		return abort(fmt.Errorf("[%s] %w", tag, err))
+	}
+	tempFile, err := os.OpenFile(rewrite_path(db.path), os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
+	if err != nil {
+		return abort(fmt.Errorf("[%s] Temporary file failed to create: %w", tag, err))
+	}
+	writer := bufio.NewWriter(tempFile)
+	fail := func(err error) error {
+		tempFile.Close()
+		return abort(err)
+	}
+
+	// Write the header, the record count and the records
+	if _, err := writer.Write(metadata); err != nil {
+		return fail(fmt.Errorf("[%s] Failed to write the metadata: %w", tag, err))
+	}
+	free := header.DataStart - int64(len(metadata))
+	if _, err := writer.Write(make([]byte, free)); err != nil {
+		return fail(fmt.Errorf("[%s] Failed to write the metadata: %w", tag, err))
+	}
+
+	// write files one by one
+	for ix, entry := range entries {
+		if shared[ix] {
+			continue
+		}
+		source := entry.source
+		if source == nil {
+			source = io.NewSectionReader(db.file, entry.record.Offset, entry.record.Stored)
+		}
+		if _, err := io.CopyN(writer, source, entry.record.Stored); err != nil {
+			return fail(fmt.Errorf("[%s] Failed to write %s: %w", tag, entry.record.FileName, err))
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return fail(fmt.Errorf("[%s] Failed to write temporary file: %w", tag, err))
+	}
+
+	if err := db.step("rewrite:write"); err != nil {
+		return fail(fmt.Errorf("[%s] %w", tag, err))
+	}
+	// replace DatabaseStructure with new one once the file is swapped
+	return db.replace(tag, tempFile, func() {
+		db.header = header
+		db.db = DatabaseStructure{
+			RecordCount: uint32(len(records)),
+			Records:     records,
+		}
+	})
+}
@@ -0,0 +1,378 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+/*
+Encrypted databases seal everything after the header with AES-256-GCM.
+The key is derived from a passphrase with PBKDF2-HMAC-SHA256 using the
+salt and rounds kept in the header, next to a check value derived from
+the key so a wrong passphrase is caught on open.
+    metadata - uint32 length, nonce and the sealed record count and
+               records, sealed with a fresh nonce on every write
+    file     - the stored data in chunks of 64 KiB, each sealed with the
+               record nonce counted up by the chunk index, the last one
+               is marked so a cut file does not open
+*/
+
+// Encryption is how a database is protected at rest
+type Encryption uint8
+
+const (
+	EncryptNone   Encryption = iota // plaintext
+	EncryptAESGCM                   // AES-256-GCM, key derived from a passphrase
+)
+
+var (
+	ErrEncrypted = errors.New("database is encrypted, a passphrase is needed")
+	ErrKey       = errors.New("wrong passphrase")
+	ErrPlain     = errors.New("database is not encrypted")
+	ErrEmptyKey  = errors.New("empty passphrase")
+)
+
+// String names the encryption
+func (e Encryption) String() string {
+	switch e {
+	case EncryptNone:
+		return "none"
+	case EncryptAESGCM:
+		return "aes-gcm"
+	}
+	return fmt.Sprintf("encryption(%d)", uint8(e))
+}
+
+const (
+	key_rounds     = 600000  // PBKDF2 rounds for new databases
+	max_key_rounds = 1 << 26 // more is a damaged header, not a slow key
+	crypt_chunk    = 64 * 1024
+)
+
+// additional data binding what a sealed block is
+var (
+	metadata_data = []byte("quark metadata")
+	name_data     = []byte("quark name")
+)
+
+// crypt seals the data and metadata of an encrypted database. A nil
+// *crypt is a plaintext database, its methods pass everything through.
+type crypt struct {
+	aead cipher.AEAD
+}
+
+// derive_key returns the PBKDF2-HMAC-SHA256 key of passphrase, one block
+// is exactly the 32 bytes AES-256 needs
+func derive_key(passphrase []byte, salt []byte, rounds uint32) []byte {
+	mac := hmac.New(sha256.New, passphrase)
+	mac.Write(salt)
+	mac.Write([]byte{0, 0, 0, 1})
+	u := mac.Sum(nil)
+	key := append([]byte(nil), u...)
+	for i := uint32(1); i < rounds; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
+
+// sub_key derives the key used for purpose from key
+func sub_key(key []byte, purpose string) (sub [32]byte) {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose))
+	mac.Sum(sub[:0])
+	return sub
+}
+
+func new_crypt(key []byte) (*crypt, error) {
+	data_key := sub_key(key, "quark data")
+	block, err := aes.NewCipher(data_key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &crypt{aead: aead}, nil
+}
+
+// encrypt_header sets up header of a new database to be encrypted with
+// a key derived from passphrase
+func encrypt_header(header *Header, passphrase []byte) (*crypt, error) {
+	header.Encryption = EncryptAESGCM
+	header.KeyRounds = key_rounds
+	if _, err := rand.Read(header.KeySalt[:]); err != nil {
+		return nil, err
+	}
+	key := derive_key(passphrase, header.KeySalt[:], header.KeyRounds)
+	header.KeyCheck = sub_key(key, "quark check")
+	return new_crypt(key)
+}
+
+// header_crypt checks passphrase against header and returns the crypt
+// of the database, nil if it is not encrypted and passphrase is nil
+func header_crypt(header Header, passphrase []byte) (*crypt, error) {
+	switch header.Encryption {
+	case EncryptNone:
+		if passphrase != nil {
+			return nil, ErrPlain
+		}
+		return nil, nil
+	case EncryptAESGCM:
+	default:
+		return nil, fmt.Errorf("%w: encryption %d", ErrVersion, header.Encryption)
+	}
+	if passphrase == nil {
+		return nil, ErrEncrypted
+	}
+	if header.KeyRounds == 0 || header.KeyRounds > max_key_rounds {
+		return nil, fmt.Errorf("%w: %d key rounds", ErrNotQuark, header.KeyRounds)
+	}
+	key := derive_key(passphrase, header.KeySalt[:], header.KeyRounds)
+	check := sub_key(key, "quark check")
+	if subtle.ConstantTimeCompare(check[:], header.KeyCheck[:]) != 1 {
+		return nil, ErrKey
+	}
+	return new_crypt(key)
+}
+
+// new_nonce returns a random record nonce, zero for plaintext databases
+func (c *crypt) new_nonce() (nonce [12]byte, err error) {
+	if c == nil {
+		return nonce, nil
+	}
+	_, err = rand.Read(nonce[:])
+	return nonce, err
+}
+
+// sealed_size is the size n stored bytes take once sealed, an empty
+// file still has its one chunk
+func (c *crypt) sealed_size(n int64) int64 {
+	if c == nil {
+		return n
+	}
+	chunks := (n + crypt_chunk - 1) / crypt_chunk
+	if chunks == 0 {
+		chunks = 1
+	}
+	return n + chunks*int64(c.aead.Overhead())
+}
+
+// chunk_nonce is the nonce of chunk index of a record
+func chunk_nonce(nonce [12]byte, index uint64) []byte {
+	binary.BigEndian.PutUint64(nonce[4:], binary.BigEndian.Uint64(nonce[4:])^index)
+	return nonce[:]
+}
+
+// chunk_data is the additional data of a chunk, marking the last one
+func chunk_data(last bool) []byte {
+	if last {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// seal_reader returns everything in r sealed with nonce, r itself for
+// plaintext databases. Closing it closes r.
+func (c *crypt) seal_reader(r io.ReadCloser, nonce [12]byte) io.ReadCloser {
+	if c == nil {
+		return r
+	}
+	return &sealer{
+		src:   r,
+		in:    bufio.NewReader(r),
+		aead:  c.aead,
+		nonce: nonce,
+		plain: make([]byte, crypt_chunk),
+	}
+}
+
+type sealer struct {
+	src    io.Closer
+	in     *bufio.Reader
+	aead   cipher.AEAD
+	nonce  [12]byte
+	index  uint64
+	plain  []byte
+	sealed []byte
+	out    []byte // sealed bytes not read yet
+	done   bool
+}
+
+func (s *sealer) Read(p []byte) (int, error) {
+	for len(s.out) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		// a chunk is the last one once nothing follows it
+		n, err := io.ReadFull(s.in, s.plain)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			s.done = true
+		} else if err != nil {
+			return 0, err
+		} else if _, err := s.in.Peek(1); err == io.EOF {
+			s.done = true
+		} else if err != nil {
+			return 0, err
+		}
+		s.sealed = s.aead.Seal(s.sealed[:0], chunk_nonce(s.nonce, s.index), s.plain[:n], chunk_data(s.done))
+		s.out = s.sealed
+		s.index++
+	}
+	n := copy(p, s.out)
+	s.out = s.out[n:]
+	return n, nil
+}
+
+func (s *sealer) Close() error {
+	return s.src.Close()
+}
+
+// open_reader returns the stored data of record in r unsealed, r itself
+// for plaintext databases. A chunk that does not open fails the read
+// with ErrChecksum.
+func (c *crypt) open_reader(r io.Reader, record Record) io.Reader {
+	if c == nil {
+		return r
+	}
+	return &opener{
+		src:    r,
+		crypt:  c,
+		record: record,
+		left:   record.Stored,
+		sealed: make([]byte, crypt_chunk+c.aead.Overhead()),
+	}
+}
+
+type opener struct {
+	src    io.Reader
+	crypt  *crypt
+	record Record
+	index  uint64
+	left   int64 // sealed bytes not read yet
+	sealed []byte
+	out    []byte // unsealed bytes not read yet
+}
+
+// open_chunk opens chunk index of the data of record into a new slice
+func (c *crypt) open_chunk(record Record, index uint64, sealed []byte, last bool) ([]byte, error) {
+	plain, err := c.aead.Open(nil, chunk_nonce(record.Nonce, index), sealed, chunk_data(last))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s (chunk %d does not open)", ErrChecksum, record.FileName, index)
+	}
+	return plain, nil
+}
+
+func (o *opener) Read(p []byte) (int, error) {
+	for len(o.out) == 0 {
+		if o.left <= 0 {
+			return 0, io.EOF
+		}
+		chunk := o.sealed[:min(o.left, int64(len(o.sealed)))]
+		if _, err := io.ReadFull(o.src, chunk); err != nil {
+			return 0, err
+		}
+		o.left -= int64(len(chunk))
+		plain, err := o.crypt.open_chunk(o.record, o.index, chunk, o.left == 0)
+		if err != nil {
+			return 0, err
+		}
+		o.out = plain
+		o.index++
+	}
+	n := copy(p, o.out)
+	o.out = o.out[n:]
+	return n, nil
+}
+
+// metadata_overhead is the room sealing adds to the metadata block
+func (c *crypt) metadata_overhead() int64 {
+	if c == nil {
+		return 0
+	}
+	return binary_size(uint32(0)) + int64(c.aead.NonceSize()+c.aead.Overhead())
+}
+
+// seal_metadata returns the record count and records in plain as they
+// are stored after the header
+func (c *crypt) seal_metadata(plain []byte) ([]byte, error) {
+	if c == nil {
+		return plain, nil
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := binary.LittleEndian.AppendUint32(nil, uint32(len(nonce)+len(plain)+c.aead.Overhead()))
+	sealed = append(sealed, nonce...)
+	return c.aead.Seal(sealed, nonce, plain, metadata_data), nil
+}
+
+// open_metadata reads the metadata block sealed by seal_metadata from r
+// and returns a reader of the record count and records
+func (c *crypt) open_metadata(r io.Reader) (io.Reader, error) {
+	if c == nil {
+		return nil, ErrEncrypted
+	}
+	var length uint32
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		return nil, fmt.Errorf("Error reading sealed metadata: %w", err)
+	}
+	sealed := make([]byte, length)
+	if _, err := io.ReadFull(r, sealed); err != nil {
+		return nil, fmt.Errorf("Error reading sealed metadata: %w", err)
+	}
+	nonce_size := c.aead.NonceSize()
+	if len(sealed) < nonce_size {
+		return nil, fmt.Errorf("%w: sealed metadata is cut", ErrChecksum)
+	}
+	plain, err := c.aead.Open(nil, sealed[:nonce_size], sealed[nonce_size:], metadata_data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: metadata does not open", ErrChecksum)
+	}
+	return bytes.NewReader(plain), nil
+}
+
+// seal_name seals a file name kept outside the database, like the one
+// in the journal
+func (c *crypt) seal_name(name string) (string, error) {
+	if c == nil || name == "" {
+		return name, nil
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return string(c.aead.Seal(nonce, nonce, []byte(name), name_data)), nil
+}
+
+// open_name opens a name sealed by seal_name
+func (c *crypt) open_name(sealed string) (string, error) {
+	if c == nil || sealed == "" {
+		return sealed, nil
+	}
+	nonce_size := c.aead.NonceSize()
+	if len(sealed) < nonce_size {
+		return "", fmt.Errorf("%w: sealed name is cut", ErrChecksum)
+	}
+	name, err := c.aead.Open(nil, []byte(sealed[:nonce_size]), []byte(sealed[nonce_size:]), name_data)
+	if err != nil {
+		return "", fmt.Errorf("%w: name does not open", ErrChecksum)
+	}
+	return string(name), nil
+}
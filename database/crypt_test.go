@@ -0,0 +1,26 @@
+package database_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"quark/database"
+	"testing"
+)
+
+// TestEmptyPassphrase creates no database under an empty passphrase
+func TestEmptyPassphrase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	for _, passphrase := range [][]byte{nil, {}} {
+		db, err := database.OpenEncrypted(path, passphrase)
+		if !errors.Is(err, database.ErrEmptyKey) {
+			if err == nil {
+				db.Close()
+			}
+			t.Fatalf("opening with %q: %v, want %v", passphrase, err, database.ErrEmptyKey)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("database created with %q", passphrase)
+		}
+	}
+}
@@ -0,0 +1,627 @@
+// Package database implements the quark single file database.
+//
+// A DB owns its file, the records read from it, the prefetch cache and
+// queue used by the Next-Potential-Caching optimization and the locks
+// guarding all of them, so several databases can be open in one process.
+// Reads share the database and read it with positional IO, so any number
+// of goroutines read at once while mutations wait for them to finish.
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+File Structure:
+    Header:
+        magic         - [5]byte "QUARK"
+        version       - uint16
+        header length - uint16
+        data start    - int64, end of the room reserved for metadata
+        compression   - uint8, default for new files
+        encryption    - uint8, none or aes-gcm
+        key salt      - [16]byte
+        key rounds    - uint32
+        key check     - [32]byte, tells a wrong passphrase
+    Record Count - uint32
+    Records:
+        name length - uint16
+        filename    - UTF-8, name length bytes
+        offset      - int64, absolute position of the file
+        size 	    - int64
+        checksum    - uint32, CRC32C of the file
+        flags       - uint8, deleted records stay as tombstones
+        compression - uint8, none or flate
+        stored      - int64, bytes the file takes in the data region
+        hash        - [32]byte, SHA-256 of the file
+        nonce       - [12]byte, seeds the nonces sealing the file
+        mtime       - int64, Unix nanoseconds
+        mode        - uint32, permission bits
+        type length - uint16
+        type        - MIME content type, type length bytes
+    Free Space:
+        up to data start
+    Files:
+        file 	 - any size
+----------------------------------------
+test.bin =>
+	header,
+	total_record_count,
+	records[file_name, offset, file_size, checksum, flags, compression, stored_size, hash, nonce, mtime, mode, content_type],
+	record_data
+The data region may contain gaps, readers only trust the offsets.
+New files are appended to the end and deletes only mark the record,
+only the metadata is rewritten. Compacting drops the dead data.
+Records with the same hash share one copy of the data, it is dead once
+the last of them is deleted.
+Encrypted databases seal the record count, records and files, see crypt.go.
+*/
+
+type DatabaseStructure struct {
+	RecordCount uint32 // live records
+	Records     []Record
+	Deleted     []Record // tombstones, their data is dead until compacted
+}
+
+// Usage is the space taken by a database
+type Usage struct {
+	Files        int
+	LiveBytes    int64 // data of the stored files as stored
+	LogicalBytes int64 // data of the stored files once decompressed
+	SharedBytes  int64 // data not stored again for identical files
+	DeadBytes    int64 // data of deleted files and gaps, freed by Compact
+	Size         int64 // size of the database file
+}
+
+var (
+	ErrClosed   = errors.New("database is closed")
+	ErrEmpty    = errors.New("database has no files written")
+	ErrNotFound = errors.New("no such file in database")
+	ErrExists   = errors.New("file already exists")
+	ErrOrder    = errors.New("order is unusable")
+	ErrChanged  = errors.New("file changed since it was opened")
+)
+
+// DB is an open quark database
+type DB struct {
+	path   string
+	file   *os.File
+	header Header
+	db     DatabaseStructure
+	index  atomic.Pointer[name_index] // of db.Records, see names
+	crypt  *crypt                     // nil unless encrypted
+
+	// reads hold lock shared, mutations hold it exclusively
+	lock     sync.RWMutex
+	log_lock sync.Mutex // serializes the read log, see LogRead
+
+	// Next-Potential-Caching state. Readers and the prefetcher change
+	// the buffers, queue and counters under cache_lock, holders of the
+	// exclusive lock change them freely.
+	opt2_flag         bool
+	last_fileinfo     []EFilePair
+	cache_lock        sync.Mutex
+	file_buffer_map   map[string]*bytes.Buffer
+	idle_queue        *SliceQueue[QueueRecord]
+	cold_read_request atomic.Bool
+	cache_hits        int
+	cache_misses      int
+
+	// memory mapped reads, see mmap.go
+	mmap_flag bool
+	mapped    []byte
+
+	fault_hook func(step string) error
+	recovered  *Recovery
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Open opens the database at filepath_db, creating an empty one if the
+// file does not exist yet. Encrypted databases fail with ErrEncrypted.
+func Open(filepath_db string) (*DB, error) {
+	return open(filepath_db, nil)
+}
+
+// OpenEncrypted opens the encrypted database at filepath_db with the key
+// derived from passphrase, creating an empty encrypted one if the file
+// does not exist yet. A wrong passphrase fails with ErrKey and a
+// plaintext database with ErrPlain. An empty passphrase fails with
+// ErrEmptyKey when the database would be created.
+func OpenEncrypted(filepath_db string, passphrase []byte) (*DB, error) {
+	if passphrase == nil {
+		passphrase = []byte{}
+	}
+	return open(filepath_db, passphrase)
+}
+
+// Encrypted reports whether the database at path is encrypted
+func Encrypted(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	header, err := read_header(file)
+	if errors.Is(err, ErrNotQuark) {
+		return false, nil // headerless version 0 or not a database
+	}
+	return header.Encryption != EncryptNone, err
+}
+
+// open opens the database at filepath_db, encrypted with passphrase if
+// it is not nil
+func open(filepath_db string, passphrase []byte) (*DB, error) {
+	filepath_db = filepath.Clean(filepath_db)
+	db := &DB{
+		path:   filepath_db,
+		header: new_header(),
+		db: DatabaseStructure{
+			RecordCount: 0,
+			Records:     []Record{},
+		},
+		file_buffer_map: make(map[string]*bytes.Buffer),
+		idle_queue:      NewSliceQueue[QueueRecord](),
+		done:            make(chan struct{}),
+	}
+
+	if _, err := os.Stat(filepath_db); os.IsNotExist(err) {
+		header := new_header()
+		if passphrase != nil && len(passphrase) == 0 {
+			return nil, fmt.Errorf("[OPEN] %w", ErrEmptyKey)
+		}
+		if passphrase != nil {
+			if db.crypt, err = encrypt_header(&header, passphrase); err != nil {
+				return nil, fmt.Errorf("[OPEN] Error setting up encryption: %w", err)
+			}
+		}
+		file, err := create_file(filepath_db, header, db.crypt)
+		if err != nil {
+			return nil, err
+		}
+		header, err = read_header(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		db.file = file
+		db.header = header
+	} else if err != nil {
+		return nil, err
+	} else {
+		//File open with read-write permissions
+		file, err := os.OpenFile(filepath_db, os.O_RDWR, os.ModePerm)
+		if err != nil {
+			return nil, fmt.Errorf("[OPEN] Error opening database: %w", err)
+		}
+		// the key is needed to recover, the key parameters never change
+		if header, err := read_header(file); err == nil {
+			if db.crypt, err = header_crypt(header, passphrase); err != nil {
+				file.Close()
+				return nil, fmt.Errorf("[OPEN] %w", err)
+			}
+		}
+		db.recovered, err = recover_journal(file, filepath_db, db.crypt)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		header, err := open_header(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if passphrase != nil && db.crypt == nil {
+			file.Close()
+			return nil, fmt.Errorf("[OPEN] %w", ErrPlain)
+		}
+		if err := read_structure(file, &header, &db.db, db.crypt); err != nil {
+			file.Close()
+			return nil, err
+		}
+		db.file = file
+		db.header = header
+		if header.Version < format_version {
+			if err := db.migrate(header.Version); err != nil {
+				file.Close()
+				return nil, err
+			}
+		}
+	}
+
+	db.wg.Add(1)
+	go db.idle_loop()
+	return db, nil
+}
+
+// open_header reads the header of file, headerless files are reported as
+// version 0. Anything without a header that is not version 0 is rejected.
+func open_header(file *os.File) (Header, error) {
+	header, err := read_header(file)
+	if errors.Is(err, ErrNotQuark) {
+		stat, v0err := file.Stat()
+		if v0err != nil {
+			return header, fmt.Errorf("[OPEN] Error checking database: %w", v0err)
+		}
+		version0, v0err := is_version0(file, stat.Size())
+		if v0err != nil {
+			return header, fmt.Errorf("[OPEN] Error checking database: %w", v0err)
+		}
+		if !version0 {
+			return header, fmt.Errorf("[OPEN] %w", err)
+		}
+		header, err = Header{Version: 0}, nil
+	}
+	return header, err
+}
+
+// metadata_start is the offset of the record count
+func (db *DB) metadata_start() int64 {
+	return int64(db.header.HeaderLength)
+}
+
+// min_metadata_capacity is the least room kept for the metadata
+const min_metadata_capacity = 4096
+
+// metadata_capacity is the room reserved for metadata of the given size,
+// twice the size so appends rarely have to move the data region
+func metadata_capacity(size int64) int64 {
+	if 2*size < min_metadata_capacity {
+		return min_metadata_capacity
+	}
+	return 2 * size
+}
+
+// metadata_size is the size of the record count and records
+func metadata_size(records ...[]Record) int64 {
+	var size int64 = binary_size(uint32(0))
+	for _, list := range records {
+		for _, record := range list {
+			size += record.encoded_size()
+		}
+	}
+	return size
+}
+
+// read_structure reads the records of a database stored by the version
+// in header, tombstones are kept apart from the live records. Versions
+// before 5 are laid out back to back after the metadata, their offsets
+// are filled in here, as is the data start of versions before 6.
+// Sealed metadata is opened with c.
+func read_structure(file io.ReadSeeker, header *Header, db *DatabaseStructure, c *crypt) error {
+	var metadata_start int64 = int64(header.HeaderLength)
+	if header.Version == 0 {
+		metadata_start = 0
+	}
+	if _, err := file.Seek(metadata_start, io.SeekStart); err != nil {
+		return fmt.Errorf("[OPEN] Error seeking records: %w", err)
+	}
+	reader := &counting_reader{r: bufio.NewReader(file)}
+	if header.Encryption != EncryptNone {
+		plain, err := c.open_metadata(reader)
+		if err != nil {
+			return fmt.Errorf("[OPEN] %w", err)
+		}
+		reader = &counting_reader{r: plain}
+	}
+	if header.Version < 2 {
+		var count uint8
+		if err := binary.Read(reader, binary.LittleEndian, &count); err != nil {
+			return fmt.Errorf("[OPEN] Error reading record count: %w", err)
+		}
+		db.RecordCount = uint32(count)
+	} else if err := binary.Read(reader, binary.LittleEndian, &db.RecordCount); err != nil {
+		return fmt.Errorf("[OPEN] Error reading record count: %w", err)
+	}
+	//	Read each record
+	for i := 0; i < int(db.RecordCount); i++ {
+		record, err := read_record(reader, header.Version)
+		if err != nil {
+			return fmt.Errorf("[OPEN] %w", err)
+		}
+		if record.flags&record_deleted != 0 {
+			db.Deleted = append(db.Deleted, record)
+			continue
+		}
+		// read records in order and send them to main db
+		db.Records = append(db.Records, record)
+	}
+	db.RecordCount = uint32(len(db.Records))
+	if header.Version < 5 {
+		location := metadata_start + reader.n
+		for ix := range db.Records {
+			db.Records[ix].Offset = location
+			location += db.Records[ix].Stored
+		}
+	}
+	if header.Version < 6 {
+		header.DataStart = metadata_start + reader.n
+	}
+	return nil
+}
+
+// Close stops the prefetcher and closes the database file
+func (db *DB) Close() error {
+	db.lock.Lock()
+	if db.file == nil {
+		db.lock.Unlock()
+		return ErrClosed
+	}
+	close(db.done)
+	db.lock.Unlock()
+
+	db.wg.Wait()
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.unmap()
+	err := db.file.Close()
+	db.file = nil
+	return err
+}
+
+// Path returns the cleaned path the database was opened with
+func (db *DB) Path() string {
+	return db.path
+}
+
+// Encryption returns how the database is protected at rest
+func (db *DB) Encryption() Encryption {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	return db.header.Encryption
+}
+
+// List returns a copy of the records in database order
+func (db *DB) List() []Record {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	records := make([]Record, len(db.db.Records))
+	copy(records, db.db.Records)
+	return records
+}
+
+// Put appends the file at filepath to the end of the database, stored
+// with the default compression of the database
+func (db *DB) Put(filepath string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	if db.file == nil {
+		return ErrClosed
+	}
+	return db.append(filepath, db.header.Compression)
+}
+
+// PutCompressed is Put storing the file with compression c
+func (db *DB) PutCompressed(filepath string, c Compression) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	if db.file == nil {
+		return ErrClosed
+	}
+	return db.append(filepath, c)
+}
+
+// PutAt writes the file at filepath to the given order in the database.
+// Placing a file between others rewrites the whole database, use Put
+// unless the order matters.
+func (db *DB) PutAt(filepath string, order uint32) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	if db.file == nil {
+		return ErrClosed
+	}
+	return db.write(filepath, order, db.header.Compression)
+}
+
+// PutAtCompressed is PutAt storing the file with compression c
+func (db *DB) PutAtCompressed(filepath string, order uint32, c Compression) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	if db.file == nil {
+		return ErrClosed
+	}
+	return db.write(filepath, order, c)
+}
+
+// Compression returns the default compression of the database
+func (db *DB) Compression() Compression {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	return db.header.Compression
+}
+
+// SetCompression changes the default compression used by Put and PutAt,
+// files already stored keep theirs
+func (db *DB) SetCompression(c Compression) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	if db.file == nil {
+		return ErrClosed
+	}
+	return db.set_compression(c)
+}
+
+// Get copies the file stored under filename into dst. When prefetching
+// is enabled the most likely next file is queued for caching.
+func (db *DB) Get(filename string, dst io.Writer) error {
+	db.cold_read_request.Store(true)
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	db.cold_read_request.Store(false)
+	if db.file == nil {
+		return ErrClosed
+	}
+	filename, err := normalize_name(filename)
+	if err != nil {
+		return fmt.Errorf("[READ] %w", err)
+	}
+	if err := db.read(filename, dst); err != nil {
+		return err
+	}
+	if db.opt2_flag {
+		db.queue_next(filename)
+	}
+	return nil
+}
+
+// Delete removes the file stored under filename from the database. Only
+// the record is marked deleted, its space is reclaimed by Compact.
+func (db *DB) Delete(filename string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	if db.file == nil {
+		return ErrClosed
+	}
+	filename, err := normalize_name(filename)
+	if err != nil {
+		return fmt.Errorf("[DELETE] %w", err)
+	}
+	return db.core_delete(filename)
+}
+
+// Replace stores the file at filepath over the stored file named
+// filename, keeping its place in the order and its compression. An empty
+// filename replaces the file stored under the base name of filepath.
+func (db *DB) Replace(filename string, filepath string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	if db.file == nil {
+		return ErrClosed
+	}
+	return db.core_replace(filename, filepath)
+}
+
+// Rename stores the file named old_name as new_name without touching
+// its data, the read log keeps what it learned about it
+func (db *DB) Rename(old_name string, new_name string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	if db.file == nil {
+		return ErrClosed
+	}
+	old_name, err := normalize_name(old_name)
+	if err != nil {
+		return fmt.Errorf("[RENAME] %w", err)
+	}
+	new_name, err = normalize_name(new_name)
+	if err != nil {
+		return fmt.Errorf("[RENAME] %w", err)
+	}
+	return db.core_rename(old_name, new_name)
+}
+
+// Reorganise rewrites the database so files are stored in the given order
+func (db *DB) Reorganise(order []string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	if db.file == nil {
+		return ErrClosed
+	}
+	return db.reorg(order)
+}
+
+// Compact rewrites the database without deleted files and gaps
+func (db *DB) Compact() error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	if db.file == nil {
+		return ErrClosed
+	}
+	return db.compact()
+}
+
+// Usage reports the live and dead bytes of the database
+func (db *DB) Usage() (Usage, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	if db.file == nil {
+		return Usage{}, ErrClosed
+	}
+	return db.usage()
+}
+
+// LogRead appends filename to the read log of the database. Logging
+// readers only wait for each other's appends.
+func (db *DB) LogRead(filename string) error {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	db.log_lock.Lock()
+	defer db.log_lock.Unlock()
+	return db.write_readLog(filename)
+}
+
+// ClearReadLog removes the read log of the database
+func (db *DB) ClearReadLog() error {
+	db.log_lock.Lock()
+	defer db.log_lock.Unlock()
+	err := os.Remove(db.readlog_path())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Occurrences builds the file transition table from the read log
+func (db *DB) Occurrences() []EFilePair {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	db.log_lock.Lock()
+	defer db.log_lock.Unlock()
+	return db.get_occurance_slice()
+}
+
+// OptimizeLayout applies the Frequent-Neighbours optimization using the
+// given transition table and returns the resulting order
+func (db *DB) OptimizeLayout(falgo_pslice []EFilePair) ([]string, error) {
+	order := optimize_algo1(falgo_pslice)
+	if order == nil {
+		return nil, nil
+	}
+	return order, db.Reorganise(order)
+}
+
+// SetPrefetch turns the Next-Potential-Caching optimization on or off.
+// A nil falgo_pslice keeps the previously used transition table.
+func (db *DB) SetPrefetch(on bool, falgo_pslice []EFilePair) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.opt2_flag = on
+	if falgo_pslice != nil {
+		db.last_fileinfo = falgo_pslice
+	}
+}
+
+// Prefetching reports whether the Next-Potential-Caching optimization is on
+func (db *DB) Prefetching() bool {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	return db.opt2_flag
+}
+
+// CacheStats returns the prefetch cache hits and misses since the last reset
+func (db *DB) CacheStats() (hits int, misses int) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	db.cache_lock.Lock()
+	defer db.cache_lock.Unlock()
+	return db.cache_hits, db.cache_misses
+}
+
+// ResetCache drops every buffered file and the cache counters
+func (db *DB) ResetCache() {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.file_buffer_map = make(map[string]*bytes.Buffer)
+	db.cache_hits, db.cache_misses = 0, 0
+}
@@ -0,0 +1,137 @@
+package database
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Progress is called while a file is extracted with the bytes of it
+// written so far and its size
+type Progress func(name string, done int64, total int64)
+
+// Extract writes the file stored under filename to dest with its recorded
+// mode and modification time. A dest that is a directory, ends in a
+// separator or is empty for the current directory gets the file under its
+// name, which must stay inside it. The file only appears once all of it
+// checked out. progress may be nil.
+func (db *DB) Extract(filename string, dest string, progress Progress) error {
+	db.cold_read_request.Store(true)
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	db.cold_read_request.Store(false)
+	if db.file == nil {
+		return ErrClosed
+	}
+	filename, err := normalize_name(filename)
+	if err != nil {
+		return fmt.Errorf("[EXTRACT] %w", err)
+	}
+	index, ok := db.find_record(filename)
+	if !ok {
+		return fmt.Errorf("[EXTRACT] %w: %s", ErrNotFound, filename)
+	}
+	record := db.db.Records[index]
+	if dest == "" {
+		dest = "."
+	}
+	if info, err := os.Stat(dest); err == nil && info.IsDir() || os.IsPathSeparator(dest[len(dest)-1]) {
+		if err := check_local(record.FileName); err != nil {
+			return fmt.Errorf("[EXTRACT] %w", err)
+		}
+		dest = filepath.Join(dest, filepath.FromSlash(record.FileName))
+	}
+	return db.extract(record, dest, progress)
+}
+
+// ExtractAll writes every file to dir under its name like Extract,
+// creating the directories the names hold. Names that would land outside
+// dir are refused before anything is written.
+func (db *DB) ExtractAll(dir string, progress Progress) error {
+	db.cold_read_request.Store(true)
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	db.cold_read_request.Store(false)
+	if db.file == nil {
+		return ErrClosed
+	}
+	for _, record := range db.db.Records {
+		if err := check_local(record.FileName); err != nil {
+			return fmt.Errorf("[EXTRACT] %w", err)
+		}
+	}
+	for _, record := range db.db.Records {
+		if err := db.extract(record, filepath.Join(dir, filepath.FromSlash(record.FileName)), progress); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// check_local refuses names that are absolute or climb out of the
+// directory they are extracted to
+func check_local(name string) error {
+	if !filepath.IsLocal(filepath.FromSlash(name)) || name == "." {
+		return fmt.Errorf("%w: %q would be written outside the destination", ErrName, name)
+	}
+	return nil
+}
+
+// extract streams the data of record into a temporary file next to path,
+// restores its attributes and renames it to path.
+// Caller must hold db.lock.
+func (db *DB) extract(record Record, path string, progress Progress) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("[EXTRACT] Can't create directory: %w", err)
+	}
+	temp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("[EXTRACT] Can't create file: %w", err)
+	}
+	fail := func(err error) error {
+		temp.Close()
+		os.Remove(temp.Name())
+		return err
+	}
+
+	var dst io.Writer = temp
+	if progress != nil {
+		dst = &progress_writer{w: temp, record: record, progress: progress}
+		progress(record.FileName, 0, record.Size)
+	}
+	if err := db.read(record.FileName, dst); err != nil {
+		return fail(fmt.Errorf("[EXTRACT] %w", err))
+	}
+	if err := temp.Close(); err != nil {
+		return fail(fmt.Errorf("[EXTRACT] Failed to write %s: %w", path, err))
+	}
+	// files written without attributes get the usual mode
+	if _, _, ok := record.Attributes(); !ok {
+		err = os.Chmod(temp.Name(), 0644)
+	} else {
+		err = record.Restore(temp.Name())
+	}
+	if err != nil {
+		return fail(fmt.Errorf("[EXTRACT] Can't restore attributes: %w", err))
+	}
+	if err := os.Rename(temp.Name(), path); err != nil {
+		return fail(fmt.Errorf("[EXTRACT] Failed to write %s: %w", path, err))
+	}
+	return nil
+}
+
+// progress_writer reports the bytes written through it
+type progress_writer struct {
+	w        io.Writer
+	record   Record
+	done     int64
+	progress Progress
+}
+
+func (p *progress_writer) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.done += int64(n)
+	p.progress(p.record.FileName, p.done, p.record.Size)
+	return n, err
+}
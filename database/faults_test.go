@@ -0,0 +1,263 @@
+package database_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"quark/database"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fault_case is a mutation run by the fault harness
+type fault_case struct {
+	name string
+	run  func(db *database.DB, names []string) error
+}
+
+// TestFaults runs every mutation against a fresh copy of a test database,
+// failing it at each of its steps in turn. After every failure the
+// database is reopened and must hold all its files, either as they were
+// before the mutation or after it.
+func TestFaults(t *testing.T) {
+	dir := t.TempDir()
+
+	// files are added until the next write has to grow the metadata
+	// and move files out of the way, that one is written by the cases
+	sources := make(map[string][]byte)
+	base := filepath.Join(dir, "base.db")
+	db, err := database.Open(base)
+	if err != nil {
+		t.Fatalf("Can't create database: %s", err)
+	}
+	var extra string
+	for i := 0; extra == ""; i++ {
+		// an empty file shares its offset with the file written after
+		// it, the first file is deleted below so it goes second
+		if i == 1 {
+			empty := filepath.Join(dir, "empty")
+			sources["empty"] = []byte{}
+			if err := os.WriteFile(empty, nil, 0644); err != nil {
+				t.Fatalf("Can't create test file: %s", err)
+			}
+			if err := db.Put(empty); err != nil {
+				t.Fatalf("Can't write test file: %s", err)
+			}
+		}
+		name := fmt.Sprintf("file-%03d-%s", i, strings.Repeat("x", 21))
+		content := bytes.Repeat([]byte(name), i+1)
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatalf("Can't create test file: %s", err)
+		}
+		sources[name] = content
+		grows := false
+		db.SetFaultHook(func(step string) error {
+			if step == "grow:move" {
+				grows = true
+				return database.ErrFault
+			}
+			return nil
+		})
+		err := db.Put(path)
+		db.SetFaultHook(nil)
+		if grows {
+			extra = path
+		} else if err != nil {
+			t.Fatalf("Can't write test file: %s", err)
+		}
+	}
+	// a tombstone takes as much room as the record it replaces
+	if err := db.Delete(db.List()[0].Name()); err != nil {
+		t.Fatalf("Can't delete test file: %s", err)
+	}
+	// a copy of a stored file shares its data
+	duplicate := filepath.Join(dir, "duplicate")
+	duplicate_of := db.List()[1].Name()
+	sources["duplicate"] = sources[duplicate_of]
+	if err := os.WriteFile(duplicate, sources[duplicate_of], 0644); err != nil {
+		t.Fatalf("Can't create test file: %s", err)
+	}
+	// replacements keep the name, one in place and one growing
+	replaced := make(map[string][]byte)
+	if err := os.Mkdir(filepath.Join(dir, "replace"), 0755); err != nil {
+		t.Fatalf("Can't create test directory: %s", err)
+	}
+	in_place, grown := db.List()[2].Name(), db.List()[3].Name()
+	replaced[in_place] = bytes.ToUpper(sources[in_place])
+	replaced[grown] = append(bytes.ToUpper(sources[grown]), "grown"...)
+	for name, content := range replaced {
+		if err := os.WriteFile(filepath.Join(dir, "replace", name), content, 0644); err != nil {
+			t.Fatalf("Can't create test file: %s", err)
+		}
+	}
+	// a rename to a long name has to grow the metadata as well
+	renamed_from := db.List()[5].Name()
+	renamed_to := strings.Repeat("renamed-", 40)
+	sources[renamed_to] = sources[renamed_from]
+	// an archive of a new file, a long name growing the metadata and a
+	// copy of a stored file
+	var archive bytes.Buffer
+	tarred := tar.NewWriter(&archive)
+	for _, name := range []string{"tarred/new", "tarred/" + strings.Repeat("tarred-", 40), "tarred/copy"} {
+		content := bytes.Repeat([]byte(name), 50)
+		if name == "tarred/copy" {
+			content = sources[duplicate_of]
+		}
+		sources[name] = content
+		tarred.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Size: int64(len(content)), Mode: 0644})
+		tarred.Write(content)
+	}
+	tarred.Close()
+	db.Close()
+
+	cases := []fault_case{
+		{"write", func(db *database.DB, names []string) error { return db.Put(extra) }},
+		{"write flate", func(db *database.DB, names []string) error { return db.PutCompressed(extra, database.CompressFlate) }},
+		{"write at", func(db *database.DB, names []string) error { return db.PutAt(extra, 3) }},
+		{"write duplicate", func(db *database.DB, names []string) error { return db.Put(duplicate) }},
+		{"replace in place", func(db *database.DB, names []string) error {
+			return db.Replace(in_place, filepath.Join(dir, "replace", in_place))
+		}},
+		{"replace", func(db *database.DB, names []string) error {
+			return db.Replace(grown, filepath.Join(dir, "replace", grown))
+		}},
+		{"fromtar", func(db *database.DB, names []string) error {
+			_, err := db.ImportTar(bytes.NewReader(archive.Bytes()))
+			return err
+		}},
+		{"rename", func(db *database.DB, names []string) error { return db.Rename(renamed_from, renamed_to) }},
+		{"delete", func(db *database.DB, names []string) error { return db.Delete(names[10]) }},
+		{"reorg", func(db *database.DB, names []string) error {
+			order := slices.Clone(names)
+			slices.Reverse(order)
+			return db.Reorganise(order)
+		}},
+		{"compact", func(db *database.DB, names []string) error { return db.Compact() }},
+	}
+
+	work := filepath.Join(dir, "work.db")
+	for _, fcase := range cases {
+		t.Run(fcase.name, func(t *testing.T) {
+			before, after, err := fault_expect(base, work, fcase)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for fail_at := 1; ; fail_at++ {
+				done, step, err := fault_run(base, work, fcase, fail_at, before, after, sources, replaced)
+				if err != nil {
+					t.Errorf("failing %s: %s", step, err)
+				}
+				if done {
+					t.Logf("%d steps", fail_at-1)
+					break
+				}
+			}
+		})
+	}
+}
+
+// fault_copy replaces work with a copy of base
+func fault_copy(base string, work string) error {
+	data, err := os.ReadFile(base)
+	if err != nil {
+		return err
+	}
+	os.Remove(work + ".journal")
+	os.Remove(work + ".rewrite")
+	return os.WriteFile(work, data, 0644)
+}
+
+// fault_names returns the file names of db in order
+func fault_names(db *database.DB) []string {
+	names := make([]string, 0)
+	for _, record := range db.List() {
+		names = append(names, record.Name())
+	}
+	return names
+}
+
+// fault_expect runs fcase without faults and returns the file names
+// before and after it
+func fault_expect(base string, work string, fcase fault_case) (before []string, after []string, err error) {
+	if err := fault_copy(base, work); err != nil {
+		return nil, nil, err
+	}
+	db, err := database.Open(work)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer db.Close()
+	before = fault_names(db)
+	if err := fcase.run(db, before); err != nil {
+		return nil, nil, err
+	}
+	return before, fault_names(db), nil
+}
+
+// fault_run runs fcase failing its fail_at-th step, then reopens the
+// database and checks it. Files hold their source or, if they were
+// replaced, their replacement. done is true once fcase has fewer steps.
+func fault_run(base string, work string, fcase fault_case, fail_at int, before []string, after []string, sources map[string][]byte, replaced map[string][]byte) (done bool, step string, err error) {
+	if err := fault_copy(base, work); err != nil {
+		return true, "", err
+	}
+	db, err := database.Open(work)
+	if err != nil {
+		return true, "", err
+	}
+	// every step from fail_at on fails, as if the process died there
+	calls := 0
+	db.SetFaultHook(func(name string) error {
+		calls++
+		if calls < fail_at {
+			return nil
+		}
+		if step == "" {
+			step = name
+		}
+		return database.ErrFault
+	})
+	run_err := fcase.run(db, before)
+	// the process dies here, nothing else is written
+	db.Close()
+	if run_err == nil && step == "" {
+		return true, "", nil
+	}
+
+	db, err = database.Open(work)
+	if err != nil {
+		return false, step, fmt.Errorf("reopen: %w", err)
+	}
+	defer db.Close()
+	names := fault_names(db)
+	if !slices.Equal(names, before) && !slices.Equal(names, after) {
+		return false, step, fmt.Errorf("files are neither as before nor after")
+	}
+	// the recovery reports which of them it left
+	if recovery, ok := db.Recovered(); ok && recovery.Op != "" && !slices.Equal(before, after) && recovery.Forward != slices.Equal(names, after) {
+		return false, step, fmt.Errorf("recovery reported the wrong outcome, completed %t", recovery.Forward)
+	}
+	for _, name := range names {
+		var buffer bytes.Buffer
+		if err := db.Get(name, &buffer); err != nil {
+			return false, step, err
+		}
+		if !bytes.Equal(buffer.Bytes(), sources[name]) && !bytes.Equal(buffer.Bytes(), replaced[name]) {
+			return false, step, fmt.Errorf("%s has changed", name)
+		}
+	}
+	// a mutation rolled back must still apply
+	if slices.Equal(names, before) && !slices.Equal(before, after) {
+		if err := fcase.run(db, before); err != nil {
+			return false, step, fmt.Errorf("retry: %w", err)
+		}
+		if !slices.Equal(fault_names(db), after) {
+			return false, step, fmt.Errorf("retry: files are not as after")
+		}
+	}
+	return false, step, nil
+}
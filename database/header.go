@@ -0,0 +1,153 @@
+package database
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// format_version is the version written by this package.
+//
+//	0: headerless, uint8 record count
+//	1: header, uint8 record count
+//	2: header, uint32 record count
+//	3: length prefixed UTF-8 file names
+//	4: CRC32C checksum per record
+//	5: absolute data offset per record
+//	6: data start in the header, metadata has room to grow
+//	7: flags per record, deletes leave a tombstone
+//	8: compression and stored size per record, default compression
+//	9: SHA-256 content hash per record, identical files share their data
+//	10: encryption and key parameters in the header, nonce per record
+//	11: modification time, mode and content type per record
+const format_version = 11
+
+var header_magic = [5]byte{'Q', 'U', 'A', 'R', 'K'}
+
+var (
+	ErrNotQuark = errors.New("not a quark database")
+	ErrVersion  = errors.New("unsupported database version")
+)
+
+type Header struct {
+	Magic        [5]byte
+	Version      uint16
+	HeaderLength uint16      // bytes up to the record count, readers skip what they don't know
+	DataStart    int64       // since version 6, the metadata may grow up to here
+	Compression  Compression // since version 8, used by writes not asking for one
+	Encryption   Encryption  // since version 10, how everything after the header is sealed
+	KeySalt      [16]byte    // PBKDF2 salt of the key
+	KeyRounds    uint32      // PBKDF2 rounds of the key
+	KeyCheck     [32]byte    // derived from the key, tells a wrong passphrase
+}
+
+// header_prefix is the part of the header every version starts with
+type header_prefix struct {
+	Magic        [5]byte
+	Version      uint16
+	HeaderLength uint16
+}
+
+func new_header() Header {
+	return Header{
+		Magic:        header_magic,
+		Version:      format_version,
+		HeaderLength: uint16(binary_size(Header{})),
+	}
+}
+
+// write_header writes header padded up to its HeaderLength
+func write_header(w io.Writer, header Header) error {
+	if err := binary.Write(w, binary.LittleEndian, header); err != nil {
+		return err
+	}
+	padding := int64(header.HeaderLength) - binary_size(header)
+	if padding > 0 {
+		_, err := w.Write(make([]byte, padding))
+		return err
+	}
+	return nil
+}
+
+// read_header reads the header at the start of file and leaves the file
+// at the end of it. Files not starting with the magic bytes return ErrNotQuark.
+func read_header(file io.ReadSeeker) (Header, error) {
+	var header Header
+	var prefix header_prefix
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return header, fmt.Errorf("[OPEN] Error seeking header: %w", err)
+	}
+	if err := binary.Read(file, binary.LittleEndian, &prefix); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return header, ErrNotQuark
+		}
+		return header, fmt.Errorf("[OPEN] Error reading header: %w", err)
+	}
+	header.Magic, header.Version, header.HeaderLength = prefix.Magic, prefix.Version, prefix.HeaderLength
+	if header.Magic != header_magic {
+		return header, ErrNotQuark
+	}
+	if header.Version > format_version {
+		return header, fmt.Errorf("[OPEN] %w: %d", ErrVersion, header.Version)
+	}
+	min_length := binary_size(prefix)
+	if header.Version >= 6 {
+		min_length += binary_size(header.DataStart)
+		if err := binary.Read(file, binary.LittleEndian, &header.DataStart); err != nil {
+			return header, fmt.Errorf("[OPEN] Error reading header: %w", err)
+		}
+	}
+	if header.Version >= 8 {
+		min_length += binary_size(header.Compression)
+		if err := binary.Read(file, binary.LittleEndian, &header.Compression); err != nil {
+			return header, fmt.Errorf("[OPEN] Error reading header: %w", err)
+		}
+	}
+	if header.Version >= 10 {
+		for _, field := range []any{&header.Encryption, &header.KeySalt, &header.KeyRounds, &header.KeyCheck} {
+			min_length += binary_size(field)
+			if err := binary.Read(file, binary.LittleEndian, field); err != nil {
+				return header, fmt.Errorf("[OPEN] Error reading header: %w", err)
+			}
+		}
+	}
+	if int64(header.HeaderLength) < min_length {
+		return header, fmt.Errorf("[OPEN] %w: header length %d", ErrNotQuark, header.HeaderLength)
+	}
+	if _, err := file.Seek(int64(header.HeaderLength), io.SeekStart); err != nil {
+		return header, fmt.Errorf("[OPEN] Error skipping header: %w", err)
+	}
+	return header, nil
+}
+
+// is_version0 reports whether file is a headerless version 0 database:
+// a uint8 record count, that many [40]byte+int64 records and exactly
+// the data they describe.
+func is_version0(file io.ReadSeeker, size int64) (bool, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	var count uint8
+	if err := binary.Read(file, binary.LittleEndian, &count); err != nil {
+		return false, nil
+	}
+	var total int64 = binary_size(count) + binary_size(legacy_record{})*int64(count)
+	for i := 0; i < int(count); i++ {
+		var record legacy_record
+		if err := binary.Read(file, binary.LittleEndian, &record); err != nil {
+			return false, nil
+		}
+		if record.Size < 0 {
+			return false, nil
+		}
+		total += record.Size
+	}
+	return total == size, nil
+}
+
+// legacy_record is the fixed size record used up to version 2
+type legacy_record struct {
+	FileName [40]byte
+	Size     int64
+}
@@ -0,0 +1,177 @@
+package database
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Filter selects the files Import takes by their name, the path relative
+// to the imported directory with / separators. Patterns are path.Match
+// globs, one without a / matches the last element of a name at any
+// depth. A file is taken when Include is empty or one of it matches, and
+// no pattern of Exclude matches, which also skips whole directories.
+type Filter struct {
+	Include []string
+	Exclude []string
+}
+
+// valid checks every pattern of the filter is a well formed glob
+func (f Filter) valid() error {
+	for _, pattern := range append(append([]string(nil), f.Include...), f.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%w: %q", err, pattern)
+		}
+	}
+	return nil
+}
+
+// glob_match reports whether any pattern matches name
+func glob_match(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		subject := name
+		if !strings.Contains(pattern, "/") {
+			subject = path.Base(name)
+		}
+		if ok, _ := path.Match(pattern, subject); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Import stores every regular file under dir the filter takes, named by
+// its path relative to dir, after the files already stored. The whole
+// tree goes in with one rewrite of the database, so it is imported
+// entirely or not at all. Returns the names imported.
+func (db *DB) Import(dir string, filter Filter) ([]string, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	if db.file == nil {
+		return nil, ErrClosed
+	}
+	return db.import_dir(dir, filter)
+}
+
+// import_dir walks dir and rewrites the database with the files it takes
+// appended. Caller must hold db.lock.
+func (db *DB) import_dir(dir string, filter Filter) ([]string, error) {
+	if err := filter.valid(); err != nil {
+		return nil, fmt.Errorf("[IMPORT] %w", err)
+	}
+	entries := make([]layout_entry, 0, len(db.db.Records))
+	for _, record := range db.db.Records {
+		entries = append(entries, layout_entry{record: record})
+	}
+	var names []string
+	taken := make(map[string]bool)
+	var sources []*import_source
+	defer func() {
+		for _, source := range sources {
+			source.Close()
+		}
+	}()
+
+	err := filepath.WalkDir(dir, func(file_path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("[IMPORT] %w", err)
+		}
+		rel, err := filepath.Rel(dir, file_path)
+		if err != nil {
+			return fmt.Errorf("[IMPORT] %w", err)
+		}
+		if rel == "." {
+			return nil
+		}
+		name := filepath.ToSlash(rel)
+		if glob_match(filter.Exclude, name) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// links and devices are not files to store
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		if len(filter.Include) > 0 && !glob_match(filter.Include, name) {
+			return nil
+		}
+
+		file, record, err := db.open_named("IMPORT", file_path, name, db.header.Compression, false)
+		if err != nil {
+			return err
+		}
+		file.Close()
+		if taken[record.FileName] {
+			return fmt.Errorf("[IMPORT] %w: %s", ErrExists, record.FileName)
+		}
+		taken[record.FileName] = true
+		names = append(names, record.FileName)
+
+		// a file already stored is copied from the database like the
+		// others, files are only opened again while rewrite reads them
+		entry_layout := layout_entry{record: record}
+		if !db.share_data(&entry_layout.record) {
+			source := &import_source{db: db, path: file_path, record: record, left: record.Stored}
+			sources = append(sources, source)
+			entry_layout.source = source
+		}
+		entries = append(entries, entry_layout)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+	if err := db.rewrite("IMPORT", "", entries); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// import_source reads the stored data of a file being imported, opening
+// it on the first read and closing it after the last so a large tree is
+// not held open at once
+type import_source struct {
+	db     *DB
+	path   string
+	record Record
+	left   int64 // stored bytes not read yet
+	file   *os.File
+	stored io.ReadCloser
+}
+
+func (s *import_source) Read(p []byte) (int, error) {
+	if s.stored == nil {
+		if s.left <= 0 {
+			return 0, io.EOF
+		}
+		file, err := os.Open(s.path)
+		if err != nil {
+			return 0, err
+		}
+		s.file = file
+		s.stored = s.db.crypt.seal_reader(compress_reader(file, s.record.Compression), s.record.Nonce)
+	}
+	n, err := s.stored.Read(p)
+	if s.left -= int64(n); s.left <= 0 {
+		s.Close()
+	}
+	return n, err
+}
+
+func (s *import_source) Close() error {
+	if s.stored == nil {
+		return nil
+	}
+	s.stored.Close()
+	s.stored = nil
+	return s.file.Close()
+}
@@ -0,0 +1,146 @@
+package database
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// name_index orders the records of a database by name so lookups and
+// prefix queries are binary searches. Mutations never change a records
+// slice once it is installed, they install a new one, so the index is
+// valid as long as it was built for the slice the database holds.
+type name_index struct {
+	records []Record // the slice the index was built for
+	order   []int    // indexes into records sorted by name
+}
+
+// names returns the name index of the current records, rebuilding it if
+// they changed. Readers holding db.lock shared may rebuild it at once,
+// an index is never changed once built. Caller must hold db.lock.
+func (db *DB) names() *name_index {
+	records := db.db.Records
+	if idx := db.index.Load(); idx != nil && len(records) == len(idx.records) && (len(records) == 0 || &records[0] == &idx.records[0]) {
+		return idx
+	}
+	order := make([]int, len(records))
+	for ix := range order {
+		order[ix] = ix
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return records[order[i]].FileName < records[order[j]].FileName
+	})
+	idx := &name_index{records: records, order: order}
+	db.index.Store(idx)
+	return idx
+}
+
+// lower returns the first position in order whose name is not below name
+func (idx *name_index) lower(name string) int {
+	return sort.Search(len(idx.order), func(i int) bool {
+		return idx.records[idx.order[i]].FileName >= name
+	})
+}
+
+// prefixed returns the positions in order of the names starting with
+// prefix, they are next to each other
+func (idx *name_index) prefixed(prefix string) (from int, to int) {
+	from = idx.lower(prefix)
+	to = from + sort.Search(len(idx.order)-from, func(i int) bool {
+		return !strings.HasPrefix(idx.records[idx.order[from+i]].FileName, prefix)
+	})
+	return from, to
+}
+
+// find_record returns the index of the record stored under filename
+func (db *DB) find_record(filename string) (int, bool) {
+	idx := db.names()
+	pos := idx.lower(filename)
+	if pos < len(idx.order) && idx.records[idx.order[pos]].FileName == filename {
+		return idx.order[pos], true
+	}
+	return -1, false
+}
+
+// Entry is a line of a directory listing, a file or a directory holding
+// files. Names with / in them are files in directories.
+type Entry struct {
+	Name   string // relative to the listed directory, directories end in /
+	Dir    bool
+	Record Record // the file, unset for directories
+	Files  int    // files in the directory and below it, 1 for a file
+	Size   int64  // size of those files
+	Stored int64  // stored size of those files
+}
+
+// ListDir lists the files and directories in the directory named by
+// prefix in name order, directories with the totals of the files below
+// them. A prefix that is not a directory lists the entries of its parent
+// starting with it, "" lists the top.
+func (db *DB) ListDir(prefix string) ([]Entry, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	if db.file == nil {
+		return nil, ErrClosed
+	}
+	idx := db.names()
+
+	dir := strings.TrimSuffix(prefix, "/")
+	if dir != "" {
+		if from, to := idx.prefixed(dir + "/"); from < to {
+			prefix = dir + "/"
+		}
+	}
+	dir = prefix[:strings.LastIndex(prefix, "/")+1]
+
+	var entries []Entry
+	from, to := idx.prefixed(prefix)
+	for _, ix := range idx.order[from:to] {
+		record := idx.records[ix]
+		name := strings.TrimPrefix(record.FileName, dir)
+		child, _, nested := strings.Cut(name, "/")
+		if !nested {
+			entries = append(entries, Entry{Name: name, Record: record, Files: 1, Size: record.Size, Stored: record.Stored})
+			continue
+		}
+		// files of a directory are next to each other in name order
+		if last := len(entries) - 1; last < 0 || entries[last].Name != child+"/" {
+			entries = append(entries, Entry{Name: child + "/", Dir: true})
+		}
+		entry := &entries[len(entries)-1]
+		entry.Files++
+		entry.Size += record.Size
+		entry.Stored += record.Stored
+	}
+	return entries, nil
+}
+
+// Glob returns the records whose names match pattern in name order.
+// Patterns are path.Match globs over the whole name, so * and ? do not
+// match /.
+func (db *DB) Glob(pattern string) ([]Record, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	if db.file == nil {
+		return nil, ErrClosed
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("[LS] %w: %q", err, pattern)
+	}
+	idx := db.names()
+
+	// only names starting with the literal part can match
+	literal := pattern
+	if meta := strings.IndexAny(pattern, `*?[\`); meta != -1 {
+		literal = pattern[:meta]
+	}
+	var records []Record
+	from, to := idx.prefixed(literal)
+	for _, ix := range idx.order[from:to] {
+		if ok, _ := path.Match(pattern, idx.records[ix].FileName); ok {
+			records = append(records, idx.records[ix])
+		}
+	}
+	return records, nil
+}
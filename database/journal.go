@@ -0,0 +1,608 @@
+package database
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+/*
+Every mutation records its intent in the journal next to the database
+before touching it, and removes the journal once it is done:
+    in place updates journal the new metadata and the database size,
+    write the new data past the old end and sync it, then write the
+    metadata over the old one.
+    overwrites journal the new data with the metadata, as the old data
+    is gone once they start, and write both over the database.
+    streamed appends journal the database size, write data past the end
+    whose records are only known once it is all read, and replace the
+    journal with that of an in place update from the old size.
+    rewrites journal the operation with the new metadata, build the new
+    database in the rewrite file, sync it and rename it over the database.
+Open finishes whatever the journal describes. New metadata is rolled
+forward once all the data it points past the old end checks out,
+otherwise the database is cut back to its old size, as it is for a
+streamed append without its metadata. Overwrites are always written
+again. A rewrite file still in place means the rename
+never happened and is removed, without one the rewrite completed only if
+the database starts with the journaled metadata.
+Journal:
+    magic    - [4]byte "QJNL"
+    kind     - uint8, in place update, rewrite, overwrite or append
+    op       - uint16 length, tag of the operation
+    name     - uint16 length, file it is applied to, sealed if encrypted
+    size     - int64, database size before the operation
+    at       - int64, overwrites only, where the data goes
+    data     - uint64 length, overwrites only, the stored data
+    metadata - uint64 length, header, record count and records
+    checksum - uint32, CRC32C of everything before it
+*/
+
+var journal_magic = [4]byte{'Q', 'J', 'N', 'L'}
+
+// journal kinds
+const (
+	journal_update    uint8 = 1 + iota // metadata written in place
+	journal_rewrite                    // database rebuilt in the rewrite file
+	journal_overwrite                  // data and metadata written in place
+	journal_append                     // data streamed past the end, metadata not known yet
+)
+
+var (
+	ErrFault   = errors.New("injected fault")
+	ErrPending = errors.New("an interrupted operation is pending, reopen the database")
+)
+
+// journal is the intent of a mutation
+type journal struct {
+	kind     uint8
+	op       string
+	name     string
+	size     int64
+	at       int64  // journal_overwrite only
+	data     []byte // journal_overwrite only
+	metadata []byte
+}
+
+// Recovery describes an interrupted operation finished by Open
+type Recovery struct {
+	Op      string // tag of the operation like WRITE, empty if the journal was torn
+	Name    string // file the operation was applied to, if any
+	Forward bool   // the operation was completed, otherwise it was rolled back
+}
+
+// SetFaultHook installs hook to be called before every step of a
+// mutation, a step fails with the returned error as if the disk did.
+// Used by the fault harness, nil removes the hook.
+func (db *DB) SetFaultHook(hook func(step string) error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.fault_hook = hook
+}
+
+// Recovered returns the operation Open found interrupted and finished
+func (db *DB) Recovered() (Recovery, bool) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	if db.recovered == nil {
+		return Recovery{}, false
+	}
+	return *db.recovered, true
+}
+
+// step runs the fault hook for the named step.
+// Caller must hold db.lock.
+func (db *DB) step(name string) error {
+	if db.fault_hook == nil {
+		return nil
+	}
+	if err := db.fault_hook(name); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// journal_path is where the journal of the database at path is kept
+func journal_path(path string) string {
+	return path + ".journal"
+}
+
+// rejournal_path is where a journal replacing the pending one is written
+func rejournal_path(path string) string {
+	return journal_path(path) + ".new"
+}
+
+// rewrite_path is where the database at path is rebuilt by rewrites
+func rewrite_path(path string) string {
+	return path + ".rewrite"
+}
+
+// sync_dir flushes the directory entries of the directory holding path
+func sync_dir(path string) error {
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// write_string writes s with its uint16 length
+func write_string(w io.Writer, s string) error {
+	if err := binary.Write(w, binary.LittleEndian, uint16(len(s))); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, s)
+	return err
+}
+
+// read_string reads a string written by write_string
+func read_string(r io.Reader) (string, error) {
+	var length uint16
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		return "", err
+	}
+	s := make([]byte, length)
+	if _, err := io.ReadFull(r, s); err != nil {
+		return "", err
+	}
+	return string(s), nil
+}
+
+// encode_journal returns j as stored in the journal file
+func encode_journal(j journal) []byte {
+	var buffer bytes.Buffer
+	buffer.Write(journal_magic[:])
+	buffer.WriteByte(j.kind)
+	write_string(&buffer, j.op)
+	write_string(&buffer, j.name)
+	binary.Write(&buffer, binary.LittleEndian, j.size)
+	if j.kind == journal_overwrite {
+		binary.Write(&buffer, binary.LittleEndian, j.at)
+		binary.Write(&buffer, binary.LittleEndian, uint64(len(j.data)))
+		buffer.Write(j.data)
+	}
+	binary.Write(&buffer, binary.LittleEndian, uint64(len(j.metadata)))
+	buffer.Write(j.metadata)
+	binary.Write(&buffer, binary.LittleEndian, crc32.Checksum(buffer.Bytes(), crc_table))
+	return buffer.Bytes()
+}
+
+// decode_journal parses a journal file, false if it is torn
+func decode_journal(data []byte) (journal, bool) {
+	var j journal
+	if len(data) < len(journal_magic)+4 {
+		return j, false
+	}
+	body, sum := data[:len(data)-4], binary.LittleEndian.Uint32(data[len(data)-4:])
+	if sum != crc32.Checksum(body, crc_table) || !bytes.HasPrefix(body, journal_magic[:]) {
+		return j, false
+	}
+	reader := bytes.NewReader(body[len(journal_magic):])
+	var length uint64
+	var err error
+	if j.kind, err = reader.ReadByte(); err != nil {
+		return j, false
+	}
+	if j.op, err = read_string(reader); err != nil {
+		return j, false
+	}
+	if j.name, err = read_string(reader); err != nil {
+		return j, false
+	}
+	if err := binary.Read(reader, binary.LittleEndian, &j.size); err != nil {
+		return j, false
+	}
+	if j.kind == journal_overwrite {
+		if err := binary.Read(reader, binary.LittleEndian, &j.at); err != nil {
+			return j, false
+		}
+		if err := binary.Read(reader, binary.LittleEndian, &length); err != nil || length > uint64(reader.Len()) {
+			return j, false
+		}
+		j.data = make([]byte, length)
+		if _, err := io.ReadFull(reader, j.data); err != nil {
+			return j, false
+		}
+	}
+	if err := binary.Read(reader, binary.LittleEndian, &length); err != nil || length != uint64(reader.Len()) {
+		return j, false
+	}
+	j.metadata = make([]byte, length)
+	if _, err := io.ReadFull(reader, j.metadata); err != nil {
+		return j, false
+	}
+	return j, true
+}
+
+// encode_metadata returns header, the record count, records and the
+// deleted records as they are stored at the start of the database
+func encode_metadata(header Header, records []Record, deleted []Record, c *crypt) ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := write_header(&buffer, header); err != nil {
+		return nil, err
+	}
+	block, err := encode_records(records, deleted, c)
+	if err != nil {
+		return nil, err
+	}
+	buffer.Write(block)
+	return buffer.Bytes(), nil
+}
+
+// encode_records returns the record count, records and deleted records
+// as they are stored after the header, sealed with c
+func encode_records(records []Record, deleted []Record, c *crypt) ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := binary.Write(&buffer, binary.LittleEndian, uint32(len(records)+len(deleted))); err != nil {
+		return nil, err
+	}
+	for _, list := range [][]Record{records, deleted} {
+		for _, record := range list {
+			if err := write_record(&buffer, record); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return c.seal_metadata(buffer.Bytes())
+}
+
+// begin records j in the journal before the database is touched.
+// Caller must hold db.lock.
+func (db *DB) begin(tag string, j journal) error {
+	path := journal_path(db.path)
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("[%s] %w", tag, ErrPending)
+	}
+	if err := db.step("journal:write"); err != nil {
+		return fmt.Errorf("[%s] %w", tag, err)
+	}
+	// the journal sits next to the database, names stay sealed
+	name, err := db.crypt.seal_name(j.name)
+	if err != nil {
+		return fmt.Errorf("[%s] Failed to seal the journal: %w", tag, err)
+	}
+	j.name = name
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("[%s] Failed to create the journal: %w", tag, err)
+	}
+	defer file.Close()
+	fail := func(err error) error {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	if _, err := file.Write(encode_journal(j)); err != nil {
+		return fail(fmt.Errorf("[%s] Failed to write the journal: %w", tag, err))
+	}
+	if err := db.step("journal:sync"); err != nil {
+		return fail(fmt.Errorf("[%s] %w", tag, err))
+	}
+	if err := file.Sync(); err != nil {
+		return fail(fmt.Errorf("[%s] Failed to sync the journal: %w", tag, err))
+	}
+	if err := sync_dir(path); err != nil {
+		return fail(fmt.Errorf("[%s] Failed to sync the journal: %w", tag, err))
+	}
+	return nil
+}
+
+// rejournal replaces the pending journal with j. The new journal is
+// written next to it and renamed over it, so either of them is in place
+// whenever the database is opened again.
+// Caller must hold db.lock.
+func (db *DB) rejournal(tag string, j journal) error {
+	path := rejournal_path(db.path)
+	if err := db.step("journal:write"); err != nil {
+		return fmt.Errorf("[%s] %w", tag, err)
+	}
+	name, err := db.crypt.seal_name(j.name)
+	if err != nil {
+		return fmt.Errorf("[%s] Failed to seal the journal: %w", tag, err)
+	}
+	j.name = name
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("[%s] Failed to create the journal: %w", tag, err)
+	}
+	defer file.Close()
+	fail := func(err error) error {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	if _, err := file.Write(encode_journal(j)); err != nil {
+		return fail(fmt.Errorf("[%s] Failed to write the journal: %w", tag, err))
+	}
+	if err := db.step("journal:sync"); err != nil {
+		return fail(fmt.Errorf("[%s] %w", tag, err))
+	}
+	if err := file.Sync(); err != nil {
+		return fail(fmt.Errorf("[%s] Failed to sync the journal: %w", tag, err))
+	}
+	if err := os.Rename(path, journal_path(db.path)); err != nil {
+		return fail(fmt.Errorf("[%s] Failed to replace the journal: %w", tag, err))
+	}
+	if err := sync_dir(path); err != nil {
+		return fmt.Errorf("[%s] Failed to sync the journal: %w", tag, err)
+	}
+	return nil
+}
+
+// end removes the journal once the operation is complete. A journal
+// left behind is finished again on open, which is harmless.
+// Caller must hold db.lock.
+func (db *DB) end(tag string) error {
+	if err := db.step("journal:remove"); err != nil {
+		return fmt.Errorf("[%s] %w", tag, err)
+	}
+	if err := os.Remove(journal_path(db.path)); err != nil {
+		return fmt.Errorf("[%s] Failed to remove the journal: %w", tag, err)
+	}
+	return nil
+}
+
+// update replaces the metadata with header, records and the deleted
+// records in place. write_data stores the data they point to past the end
+// of the database first, anything failing before the metadata is written
+// cuts the database back to its old size.
+// Caller must hold db.lock and make sure the metadata fits before the
+// data start.
+func (db *DB) update(tag string, name string, header Header, records []Record, deleted []Record, write_data func() error) error {
+	stat, err := db.file.Stat()
+	if err != nil {
+		return fmt.Errorf("[%s] Can't read database: %w", tag, err)
+	}
+	return db.update_from(tag, name, stat.Size(), false, header, records, deleted, write_data)
+}
+
+// update_from is update for a database that was size bytes long when the
+// operation started. appended is set when data was streamed past size
+// under a journal_append already, which the update journal replaces.
+// Caller must hold db.lock.
+func (db *DB) update_from(tag string, name string, size int64, appended bool, header Header, records []Record, deleted []Record, write_data func() error) error {
+	rollback := func(cause error) error {
+		if db.step("rollback") != nil {
+			return cause
+		}
+		if db.file.Truncate(size) == nil && db.file.Sync() == nil {
+			os.Remove(journal_path(db.path))
+		}
+		return cause
+	}
+	metadata, err := encode_metadata(header, records, deleted, db.crypt)
+	if err != nil {
+		err = fmt.Errorf("[%s] Failed to encode the metadata: %w", tag, err)
+	} else if appended {
+		err = db.rejournal(tag, journal{kind: journal_update, op: tag, name: name, size: size, metadata: metadata})
+	} else {
+		err = db.begin(tag, journal{kind: journal_update, op: tag, name: name, size: size, metadata: metadata})
+	}
+	if err != nil {
+		if appended {
+			// the streamed data is still covered by its own journal
+			return rollback(err)
+		}
+		return err
+	}
+	if err := write_data(); err != nil {
+		return rollback(err)
+	}
+	if err := db.step("data:sync"); err != nil {
+		return rollback(fmt.Errorf("[%s] %w", tag, err))
+	}
+	if err := db.file.Sync(); err != nil {
+		return rollback(fmt.Errorf("[%s] Failed to sync the database: %w", tag, err))
+	}
+
+	// from here on the journal is only finished on open
+	if err := db.step("metadata:write"); err != nil {
+		return fmt.Errorf("[%s] %w", tag, err)
+	}
+	if _, err := db.file.WriteAt(metadata, 0); err != nil {
+		return fmt.Errorf("[%s] Failed to write the metadata: %w", tag, err)
+	}
+	if err := db.step("metadata:sync"); err != nil {
+		return fmt.Errorf("[%s] %w", tag, err)
+	}
+	if err := db.file.Sync(); err != nil {
+		return fmt.Errorf("[%s] Failed to sync the database: %w", tag, err)
+	}
+	// the mapping takes in the data past the old end
+	db.remap()
+	return db.end(tag)
+}
+
+// overwrite writes data at offset at over the data of the database and
+// replaces the metadata with header, records and the deleted records.
+// The old data is lost as soon as it starts, so once the journal holding
+// the data is written a failure leaves it to be finished on open.
+// Caller must hold db.lock and make sure the metadata fits before the
+// data start.
+func (db *DB) overwrite(tag string, name string, header Header, records []Record, deleted []Record, at int64, data []byte) error {
+	metadata, err := encode_metadata(header, records, deleted, db.crypt)
+	if err != nil {
+		return fmt.Errorf("[%s] Failed to encode the metadata: %w", tag, err)
+	}
+	if err := db.begin(tag, journal{kind: journal_overwrite, op: tag, name: name, at: at, data: data, metadata: metadata}); err != nil {
+		return err
+	}
+	if err := db.step("data:write"); err != nil {
+		return fmt.Errorf("[%s] %w", tag, err)
+	}
+	if _, err := db.file.WriteAt(data, at); err != nil {
+		return fmt.Errorf("[%s] Failed to write the new data: %w", tag, err)
+	}
+	if err := db.step("metadata:write"); err != nil {
+		return fmt.Errorf("[%s] %w", tag, err)
+	}
+	if _, err := db.file.WriteAt(metadata, 0); err != nil {
+		return fmt.Errorf("[%s] Failed to write the metadata: %w", tag, err)
+	}
+	if err := db.step("metadata:sync"); err != nil {
+		return fmt.Errorf("[%s] %w", tag, err)
+	}
+	if err := db.file.Sync(); err != nil {
+		return fmt.Errorf("[%s] Failed to sync the database: %w", tag, err)
+	}
+	return db.end(tag)
+}
+
+// replace syncs the rewrite file and renames it over the database, which
+// is then served from it and swapped is called. The rewrite file is
+// removed instead if anything fails before the rename.
+// Caller must hold db.lock and have begun a journal_rewrite.
+func (db *DB) replace(tag string, temp *os.File, swapped func()) error {
+	renamed := false
+	defer func() {
+		if !renamed {
+			temp.Close()
+			if db.step("rollback") == nil && os.Remove(rewrite_path(db.path)) == nil {
+				os.Remove(journal_path(db.path))
+			}
+		}
+	}()
+	if stat, err := db.file.Stat(); err == nil {
+		temp.Chmod(stat.Mode().Perm())
+	}
+	if err := db.step("rewrite:sync"); err != nil {
+		return fmt.Errorf("[%s] %w", tag, err)
+	}
+	if err := temp.Sync(); err != nil {
+		return fmt.Errorf("[%s] Failed to sync temporary file: %w", tag, err)
+	}
+	if err := db.step("rewrite:rename"); err != nil {
+		return fmt.Errorf("[%s] %w", tag, err)
+	}
+	if err := os.Rename(temp.Name(), db.path); err != nil {
+		return fmt.Errorf("[%s] Failed to replace the database: %w", tag, err)
+	}
+	renamed = true
+	db.file.Close()
+	db.file = temp
+	swapped()
+	db.remap()
+
+	if err := db.step("rewrite:syncdir"); err != nil {
+		return fmt.Errorf("[%s] %w", tag, err)
+	}
+	if err := sync_dir(db.path); err != nil {
+		return fmt.Errorf("[%s] Failed to sync the database directory: %w", tag, err)
+	}
+	return db.end(tag)
+}
+
+// recover_journal finishes the operation journaled for the database at
+// path before it is read. Returns nil if there was none.
+func recover_journal(file *os.File, path string, c *crypt) (*Recovery, error) {
+	data, err := os.ReadFile(journal_path(path))
+	if os.IsNotExist(err) {
+		// a rewrite file without a journal never got any data
+		os.Remove(rewrite_path(path))
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[RECOVER] Error reading the journal: %w", err)
+	}
+
+	// a torn journal was never acted on
+	recovery := &Recovery{}
+	if j, ok := decode_journal(data); ok {
+		recovery.Op = j.op
+		recovery.Name, _ = c.open_name(j.name)
+		switch j.kind {
+		case journal_update:
+			recovery.Forward, err = recover_update(file, j, c)
+		case journal_overwrite:
+			recovery.Forward, err = true, recover_overwrite(file, j)
+		case journal_append:
+			if err = file.Truncate(j.size); err == nil {
+				err = file.Sync()
+			}
+		case journal_rewrite:
+			err = os.Remove(rewrite_path(path))
+			if os.IsNotExist(err) {
+				recovery.Forward, err = recover_rewrite(file, j)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("[RECOVER] Failed to finish %s: %w", j.op, err)
+		}
+	}
+	os.Remove(rewrite_path(path))
+	os.Remove(rejournal_path(path))
+	if err := os.Remove(journal_path(path)); err != nil {
+		return nil, fmt.Errorf("[RECOVER] Failed to remove the journal: %w", err)
+	}
+	if err := sync_dir(path); err != nil {
+		return nil, fmt.Errorf("[RECOVER] Failed to sync the database directory: %w", err)
+	}
+	return recovery, nil
+}
+
+// recover_rewrite reports whether the rewrite file of j replaced the
+// database, it is gone either way. It did if the database starts with the
+// journaled metadata, journals without it are taken to have completed.
+func recover_rewrite(file *os.File, j journal) (bool, error) {
+	if len(j.metadata) == 0 {
+		return true, nil
+	}
+	metadata := make([]byte, len(j.metadata))
+	n, err := file.ReadAt(metadata, 0)
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	return bytes.Equal(metadata[:n], j.metadata), nil
+}
+
+// recover_update writes the journaled metadata over the database if all
+// the data it points to past the old end is there, otherwise the database
+// is cut back to its old size. Reports whether it rolled forward.
+func recover_update(file *os.File, j journal, c *crypt) (bool, error) {
+	reader := bytes.NewReader(j.metadata)
+	header, err := read_header(reader)
+	if err != nil {
+		return false, err
+	}
+	var structure DatabaseStructure
+	if err := read_structure(reader, &header, &structure, c); err != nil {
+		return false, err
+	}
+	forward := true
+	for _, record := range structure.Records {
+		if record.Offset < j.size {
+			continue
+		}
+		if verify_stored(io.NewSectionReader(file, record.Offset, record.Stored), record, c) != nil {
+			forward = false
+			break
+		}
+	}
+	if forward {
+		_, err = file.WriteAt(j.metadata, 0)
+	} else {
+		err = file.Truncate(j.size)
+	}
+	if err != nil {
+		return false, err
+	}
+	return forward, file.Sync()
+}
+
+// recover_overwrite writes the journaled data and metadata over the
+// database again
+func recover_overwrite(file *os.File, j journal) error {
+	if _, err := file.WriteAt(j.data, j.at); err != nil {
+		return err
+	}
+	if _, err := file.WriteAt(j.metadata, 0); err != nil {
+		return err
+	}
+	return file.Sync()
+}
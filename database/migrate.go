@@ -0,0 +1,93 @@
+package database
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// counting_reader counts the bytes read through it
+type counting_reader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *counting_reader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// read_legacy_record reads the fixed size record used up to version 2
+func read_legacy_record(r io.Reader) (Record, error) {
+	var old legacy_record
+	if err := binary.Read(r, binary.LittleEndian, &old); err != nil {
+		return Record{}, fmt.Errorf("Error reading record: %w", err)
+	}
+	return Record{
+		FileName: string(bytes.TrimRight(old.FileName[:], "\x00")),
+		Size:     old.Size,
+		Stored:   old.Size,
+	}, nil
+}
+
+// migrate upgrades the structure read from an older version to the
+// current format and rewrites the database with it. Offsets missing
+// before version 5 were filled in by read_structure.
+// Caller must hold db.lock.
+func (db *DB) migrate(from uint16) error {
+	records := db.db.Records
+	if from < 3 {
+		// names cut mid-rune lose the partial rune and names that
+		// collided through truncation get a "~n" suffix so every
+		// record stays reachable
+		seen := make(map[string]bool)
+		for ix := range records {
+			name := strings.ToValidUTF8(records[ix].FileName, "")
+			name, err := normalize_name(name)
+			if err != nil {
+				name = fmt.Sprintf("file%d", ix)
+			}
+			unique := name
+			for n := 1; seen[unique]; n++ {
+				unique = fmt.Sprintf("%s~%d", name, n)
+			}
+			seen[unique] = true
+			records[ix].FileName = unique
+		}
+	}
+	if from < 4 {
+		// checksums are computed from the data as it is stored now
+		for ix := range records {
+			sum, err := checksum_reader(io.NewSectionReader(db.file, records[ix].Offset, records[ix].Size))
+			if err != nil {
+				return fmt.Errorf("[MIGRATE] Error reading %s: %w", records[ix].FileName, err)
+			}
+			records[ix].Checksum = sum
+		}
+	}
+
+	if from < 9 {
+		// hashes let identical files share their data from now on
+		for ix := range records {
+			hash, err := hash_stored(io.NewSectionReader(db.file, records[ix].Offset, records[ix].Stored), records[ix])
+			if err != nil {
+				return fmt.Errorf("[MIGRATE] Error reading %s: %w", records[ix].FileName, err)
+			}
+			records[ix].Hash = hash
+		}
+	}
+
+	entries := make([]layout_entry, 0, len(records))
+	for _, record := range records {
+		entries = append(entries, layout_entry{record: record})
+	}
+	// the default compression and the key stay
+	header := new_header()
+	header.Compression = db.header.Compression
+	header.Encryption, header.KeySalt, header.KeyRounds, header.KeyCheck = db.header.Encryption, db.header.KeySalt, db.header.KeyRounds, db.header.KeyCheck
+	db.header = header
+	return db.rewrite("MIGRATE", "", entries)
+}
@@ -0,0 +1,91 @@
+package database_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"quark/database"
+	"strings"
+	"testing"
+)
+
+// legacy_file builds a version 0 or 1 database holding the files in
+// order, their names cut to the 40 bytes the format had room for
+func legacy_file(t *testing.T, version uint16, names []string, data []string) []byte {
+	t.Helper()
+	var file bytes.Buffer
+	write := func(value any) {
+		if err := binary.Write(&file, binary.LittleEndian, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if version == 1 {
+		file.WriteString("QUARK")
+		write(version)
+		write(uint16(9)) // header length, the magic and the two fields
+	}
+	write(uint8(len(names)))
+	for ix, name := range names {
+		var record struct {
+			FileName [40]byte
+			Size     int64
+		}
+		copy(record.FileName[:], name)
+		record.Size = int64(len(data[ix]))
+		write(record)
+	}
+	for _, content := range data {
+		file.WriteString(content)
+	}
+	return file.Bytes()
+}
+
+// TestMigrateLegacy opens version 0 and 1 databases. Every file keeps
+// its data under its name, a name cut mid-rune loses the partial rune
+// and names that collided through the cut get a ~n suffix.
+func TestMigrateLegacy(t *testing.T) {
+	names := []string{
+		"notes.txt",
+		strings.Repeat("c", 39) + "é.txt", // the cut falls inside é
+		strings.Repeat("b", 40) + "-1.txt",
+		strings.Repeat("b", 40) + "-2.txt",
+	}
+	data := []string{"some notes", "ccc", "the first b", "the second b, longer"}
+	want := []string{
+		"notes.txt",
+		strings.Repeat("c", 39),
+		strings.Repeat("b", 40),
+		strings.Repeat("b", 40) + "~1",
+	}
+
+	for _, version := range []uint16{0, 1} {
+		path := filepath.Join(t.TempDir(), "legacy.db")
+		if err := os.WriteFile(path, legacy_file(t, version, names, data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		// the second open reads what the first rewrote
+		for _, pass := range []string{"migrated", "reopened"} {
+			db, err := database.Open(path)
+			if err != nil {
+				t.Fatalf("version %d %s: %v", version, pass, err)
+			}
+			records := db.List()
+			if len(records) != len(want) {
+				t.Fatalf("version %d %s: %d files, want %d", version, pass, len(records), len(want))
+			}
+			for ix, record := range records {
+				if record.FileName != want[ix] {
+					t.Errorf("version %d %s: file %d is %q, want %q", version, pass, ix, record.FileName, want[ix])
+					continue
+				}
+				var got bytes.Buffer
+				if err := db.Get(want[ix], &got); err != nil || got.String() != data[ix] {
+					t.Errorf("version %d %s: %s reads %q, %v", version, pass, want[ix], got.String(), err)
+				}
+			}
+			check_clean(t, db)
+			db.Close()
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+var ErrMmap = errors.New("memory mapping is not supported on this platform")
+
+// SetMmap switches reads to a memory mapping of the database, which
+// serves them as slices of it without a system call each, or back to
+// reading the file. The mapping follows the database through mutations.
+func (db *DB) SetMmap(on bool) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	if db.file == nil {
+		return ErrClosed
+	}
+	db.mmap_flag = on
+	return db.remap()
+}
+
+// Mmapped reports whether reads are served from a memory mapping
+func (db *DB) Mmapped() bool {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	return db.mapped != nil
+}
+
+// remap replaces the mapping with one of the database as it is now when
+// mapping is on. Mutations call it once they are done and ignore the
+// error, a database that can't be mapped is read from the file.
+// Caller must hold db.lock.
+func (db *DB) remap() error {
+	if err := db.unmap(); err != nil {
+		return fmt.Errorf("[MMAP] Failed to unmap the database: %w", err)
+	}
+	if !db.mmap_flag {
+		return nil
+	}
+	stat, err := db.file.Stat()
+	if err != nil {
+		return fmt.Errorf("[MMAP] Can't read database: %w", err)
+	}
+	// nothing to map yet, reads find no files
+	if stat.Size() == 0 {
+		return nil
+	}
+	if stat.Size() > math.MaxInt {
+		return fmt.Errorf("[MMAP] Database of %d bytes does not fit the address space", stat.Size())
+	}
+	mapped, err := map_file(db.file, int(stat.Size()))
+	if err != nil {
+		return fmt.Errorf("[MMAP] Failed to map the database: %w", err)
+	}
+	db.mapped = mapped
+	return nil
+}
+
+// unmap releases the mapping, reads go to the file again.
+// Caller must hold db.lock.
+func (db *DB) unmap() error {
+	if db.mapped == nil {
+		return nil
+	}
+	err := unmap_file(db.mapped)
+	db.mapped = nil
+	return err
+}
+
+// stored_range returns a reader of length bytes of the database from
+// off, a slice of the mapping when it holds them.
+// Caller must hold db.lock.
+func (db *DB) stored_range(off int64, length int64) io.Reader {
+	if off >= 0 && off+length <= int64(len(db.mapped)) {
+		return bytes.NewReader(db.mapped[off : off+length])
+	}
+	return io.NewSectionReader(db.file, off, length)
+}
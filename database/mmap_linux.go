@@ -0,0 +1,19 @@
+//go:build linux
+
+package database
+
+import (
+	"os"
+	"syscall"
+)
+
+// map_file maps the first size bytes of file read only. Writes through
+// the file show in the mapping, it shares the page cache with them.
+func map_file(file *os.File, size int) ([]byte, error) {
+	return syscall.Mmap(int(file.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+// unmap_file releases a mapping made by map_file
+func unmap_file(data []byte) error {
+	return syscall.Munmap(data)
+}
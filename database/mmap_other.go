@@ -0,0 +1,15 @@
+//go:build !linux
+
+package database
+
+import "os"
+
+// map_file fails, databases are only mapped on Linux
+func map_file(file *os.File, size int) ([]byte, error) {
+	return nil, ErrMmap
+}
+
+// unmap_file has nothing to release
+func unmap_file(data []byte) error {
+	return nil
+}
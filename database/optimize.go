@@ -0,0 +1,275 @@
+package database
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+type Readlog struct {
+	FileName string
+	Time     int64
+}
+
+type FileMap map[string]EFileInfo // from filename to maximum edge
+
+type EFilePair struct {
+	Fname string
+	Info  EFileInfo
+}
+
+type EFileInfo struct {
+	TotalWeight int
+	MaxEdges    []string
+}
+
+// readlog_path is where the read log of the database is kept
+func (db *DB) readlog_path() string {
+	return "./logs/" + logfilename(filepath.Base(db.path))
+}
+
+// write_readLog appends filename to the read log. Encrypted databases
+// keep no read log, it would list their file names in the clear.
+// Caller must hold db.lock.
+func (db *DB) write_readLog(filename string) error {
+	/* READLOG
+	Writing read order of each read file
+	filename	|	time
+	1.txt		|	181.1µs
+	*/
+	if db.db.RecordCount == 0 || db.crypt != nil {
+		return nil
+	}
+	filename, err := normalize_name(filename)
+	if err != nil {
+		return nil
+	}
+	if _, ok := db.find_record(filename); !ok {
+		// if file does not exist, exit
+		return nil
+	}
+
+	// name of csv file "./logs/filename.csv"
+	csvPath := db.readlog_path()
+	// create logs folder if it doesn't exist
+	_, err = os.Stat("./logs")
+	if os.IsNotExist(err) {
+		// Folder doesn't exist, create it
+		err := os.Mkdir("./logs", 0755)
+		if err != nil {
+			return fmt.Errorf("[READLOG] Error creating folder: %w", err)
+		}
+	}
+
+	_, err_stat := os.Stat(csvPath)
+
+	// Open the CSV file in append mode
+	file, err := os.OpenFile(csvPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("[READLOG] Error opening CSV file: %w", err)
+	}
+	defer file.Close()
+
+	// Create a CSV writer
+	writer := csv.NewWriter(file)
+	// Check file's existance
+	if os.IsNotExist(err_stat) {
+		headers := []string{"filename", "time"}
+		if err := writer.Write(headers); err != nil {
+			return fmt.Errorf("[READLOG] Error writing headers to CSV: %w", err)
+		}
+	}
+
+	fileReadTime := time.Now().Unix()
+
+	row := []string{filename, strconv.FormatInt(fileReadTime, 10)}
+
+	if err := writer.Write(row); err != nil {
+		return fmt.Errorf("[READLOG] Error writing row to CSV: %w", err)
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// rename_readlog rewrites the read log with old_name read as new_name,
+// so the transitions learned for the file are kept.
+// Caller must hold db.lock.
+func (db *DB) rename_readlog(old_name string, new_name string) error {
+	csvPath := db.readlog_path()
+	file, err := os.Open(csvPath)
+	if os.IsNotExist(err) {
+		return nil // nothing read yet
+	}
+	if err != nil {
+		return err
+	}
+	rows, err := csv.NewReader(file).ReadAll()
+	file.Close()
+	if err != nil {
+		return err
+	}
+	// the first row holds the headers
+	for ix := 1; ix < len(rows); ix++ {
+		if len(rows[ix]) > 0 && rows[ix][0] == old_name {
+			rows[ix][0] = new_name
+		}
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(csvPath), filepath.Base(csvPath)+".tmp*")
+	if err != nil {
+		return err
+	}
+	temp.Chmod(0644)
+	writer := csv.NewWriter(temp)
+	writer.WriteAll(rows)
+	if err := writer.Error(); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	return os.Rename(temp.Name(), csvPath)
+}
+
+// get_occurance_slice builds the transition table of every record from
+// the read log, heaviest first. Caller must hold db.lock.
+func (db *DB) get_occurance_slice() []EFilePair {
+	records := read_readlog(db.readlog_path())
+	if records == nil {
+		return nil // nothing to optimize
+	}
+	falgo_pslice := make([]EFilePair, 0)
+	// init all edges
+	for _, recdb := range db.db.Records {
+		fnname := recdb.FileName
+		falgo_pslice = append(falgo_pslice, EFilePair{
+			Fname: fnname,
+			Info:  calculate_occurance(records, fnname),
+		})
+	}
+	// sort them
+	sort.Slice(falgo_pslice, func(i, j int) bool {
+		return falgo_pslice[i].Info.TotalWeight > falgo_pslice[j].Info.TotalWeight
+	})
+	if len(falgo_pslice) < 1 {
+		return nil
+	}
+	return falgo_pslice
+}
+
+// optimize_algo1 orders files so frequent neighbours are stored together
+func optimize_algo1(falgo_pslice []EFilePair) []string {
+	if falgo_pslice == nil {
+		return nil
+	}
+	final_res := make([]string, 0)
+
+	falgo := falgo_pslice[0]
+	final_res = append(final_res, falgo.Fname) // first
+
+	var next_falgo = ""
+	if len(falgo.Info.MaxEdges) > 0 { // second
+		next_falgo = falgo.Info.MaxEdges[0]
+		if next_falgo != "" {
+			final_res = append(final_res, next_falgo)
+		}
+	}
+
+	var new_pairs []string = nil
+MainLoop:
+	for { // rest
+		new_pairs = find_occurance(falgo_pslice, next_falgo)
+		if new_pairs == nil {
+			for _, val := range falgo_pslice {
+				if !string_contains(final_res, val.Fname) {
+					new_pairs = find_occurance(falgo_pslice, val.Fname)
+					final_res = append(final_res, val.Fname)
+					break
+				}
+			}
+			if new_pairs == nil {
+				break MainLoop
+			}
+		}
+		for _, nexter_fname := range new_pairs {
+			if nexter_fname == "" {
+				next_falgo = ""
+				break
+			}
+			if !string_contains(final_res, nexter_fname) {
+				final_res = append(final_res, nexter_fname)
+				next_falgo = nexter_fname
+				break
+			}
+		}
+	}
+	return final_res
+}
+
+func find_occurance(falgo_pslice []EFilePair, next_falgo string) []string {
+	if next_falgo == "" {
+		return nil
+	}
+	for _, val := range falgo_pslice {
+		if val.Fname != next_falgo {
+			continue
+		}
+		return val.Info.MaxEdges
+	}
+	return nil
+}
+
+func calculate_occurance(records []Readlog, fnname string) EFileInfo {
+	var total_weight = 0
+
+	var weight_map = make(map[string]int)
+	for ir, rec := range records {
+		cur_fname := rec.FileName
+		if cur_fname != fnname {
+			continue
+		}
+		if ir+1 == len(records) {
+			total_weight++
+			break
+		}
+		next_fname := records[ir+1].FileName
+		if cur_fname == next_fname {
+			total_weight++
+			continue
+		}
+		total_weight++
+		weight_map[next_fname] += 1
+	}
+
+	type Pair struct {
+		Key   string
+		Value int
+	}
+	var pairs []Pair
+	for k, v := range weight_map {
+		pairs = append(pairs, Pair{k, v})
+	}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].Value < pairs[j].Value
+	})
+
+	max_edges := make([]string, 3)
+	for ix, v := range pairs {
+		if ix > 2 {
+			break
+		}
+		max_edges[ix] = v.Key
+	}
+	return EFileInfo{
+		TotalWeight: total_weight,
+		MaxEdges:    max_edges,
+	}
+}
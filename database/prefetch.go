@@ -0,0 +1,162 @@
+package database
+
+import (
+	"bytes"
+	"time"
+)
+
+const chunkSize = 1048576
+
+type QueueRecord struct {
+	FileName string
+	SizeRead int64
+}
+
+// queue_next queues the most likely file to be read after filename.
+// Caller must hold db.lock.
+func (db *DB) queue_next(filename string) {
+	if db.last_fileinfo == nil {
+		return
+	}
+	var next_file = ""
+	for _, val := range db.last_fileinfo {
+		if filename == val.Fname {
+			next_file = val.Info.MaxEdges[0]
+			break
+		}
+	}
+	if next_file != "" {
+		db.cache_lock.Lock()
+		db.idle_queue.Enqueue(QueueRecord{FileName: next_file, SizeRead: 0})
+		db.cache_lock.Unlock()
+	}
+}
+
+// buffered returns the stored bytes of filename the prefetcher read so
+// far, false if it has no buffer for it. The prefetcher only appends to
+// a buffer, so the bytes returned don't change under the caller.
+// Caller must hold db.lock.
+func (db *DB) buffered(filename string) ([]byte, bool) {
+	db.cache_lock.Lock()
+	defer db.cache_lock.Unlock()
+	buff := db.file_buffer_map[filename]
+	if buff == nil {
+		return nil, false
+	}
+	return buff.Bytes(), true
+}
+
+// count_read counts a read served entirely from a prefetch buffer as a
+// hit and any other as a miss. Caller must hold db.lock.
+func (db *DB) count_read(hit bool) {
+	db.cache_lock.Lock()
+	defer db.cache_lock.Unlock()
+	if hit {
+		db.cache_hits += 1
+	} else {
+		db.cache_misses += 1
+	}
+}
+
+// idle_loop fills the prefetch buffers from the queue whenever no
+// foreground read is waiting for the database. It reads alongside the
+// readers and gives way to mutations.
+func (db *DB) idle_loop() {
+	defer db.wg.Done()
+	for {
+		select {
+		case <-db.done:
+			return
+		default:
+		}
+		if db.cold_read_request.Load() || !db.lock.TryRLock() {
+			time.Sleep(time.Second / 10)
+			continue
+		}
+		db.cache_lock.Lock()
+		qitem, ok := db.idle_queue.Peek()
+		db.cache_lock.Unlock()
+		if !ok {
+			db.lock.RUnlock()
+			time.Sleep(time.Millisecond)
+			continue
+		}
+		total_read, file_size := db.read_next(qitem.FileName)
+		// readers only queue behind the front item
+		db.cache_lock.Lock()
+		if total_read == file_size {
+			db.idle_queue.Dequeue()
+		} else {
+			db.idle_queue.items[0].SizeRead = total_read
+		}
+		db.cache_lock.Unlock()
+		db.lock.RUnlock()
+	}
+}
+
+// read_next continues reading next_file into its prefetch buffer,
+// giving up early when a foreground read arrives. Only the prefetcher
+// writes to the buffers, readers look at them under cache_lock.
+// Caller must hold db.lock.
+func (db *DB) read_next(next_file string) (total_read int64, file_size int64) {
+	// FROM CORE.READ //////////////
+	// fail if we didn't write any files yet
+	if db.db.RecordCount == 0 {
+		return 0, file_size
+	}
+	index, ok := db.find_record(next_file)
+	if !ok {
+		return 0, file_size
+	}
+	found := db.db.Records[index]
+	file_size = found.Stored
+	location := found.Offset
+	/////////////////////////////
+	db.cache_lock.Lock()
+	buffy := db.file_buffer_map[next_file]
+	if buffy == nil {
+		buffy = bytes.NewBuffer([]byte{})
+		db.file_buffer_map[next_file] = buffy
+	}
+	db.cache_lock.Unlock()
+	if int64(buffy.Len()) == file_size {
+		return file_size, file_size
+	}
+
+	// continue from where the last prefetch stopped, at a position of
+	// its own so readers don't lose theirs
+	for {
+		lcsize := chunkSize
+		if chunkSize+buffy.Len() > int(file_size) {
+			lcsize = int(file_size) - buffy.Len()
+		}
+		if lcsize == 0 {
+			break
+		}
+		buf := make([]byte, lcsize)
+
+		n, err := db.file.ReadAt(buf, location+int64(buffy.Len()))
+		if n > 0 {
+			db.cache_lock.Lock()
+			buffy.Write(buf[:n])
+			db.cache_lock.Unlock()
+		}
+		if err != nil {
+			// test me
+			break
+		}
+		if db.cold_read_request.Load() {
+			break
+		}
+	}
+	if int64(buffy.Len()) == file_size {
+		// a corrupt copy is dropped so the foreground read goes to
+		// the database and reports the mismatch itself
+		if verify_stored(bytes.NewReader(buffy.Bytes()), found, db.crypt) != nil {
+			db.cache_lock.Lock()
+			delete(db.file_buffer_map, next_file)
+			db.cache_lock.Unlock()
+		}
+	}
+	return int64(buffy.Len()), file_size
+}
@@ -0,0 +1,205 @@
+package database
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	ErrName     = errors.New("invalid file name")
+	ErrChecksum = errors.New("checksum mismatch")
+)
+
+// crc_table is used for the CRC32C checksum of every record
+var crc_table = crc32.MakeTable(crc32.Castagnoli)
+
+type Record struct {
+	FileName    string
+	Offset      int64 // absolute position of the file data
+	Size        int64
+	Checksum    uint32 // CRC32C of the file data
+	Compression Compression
+	Stored      int64    // bytes taken in the data region, Size unless compressed
+	Hash        [32]byte // SHA-256 of the file data, records with the same hash share it
+	Nonce       [12]byte // seeds the nonces sealing the data, zero unless encrypted
+	ModTime     int64    // modification time in Unix nanoseconds, see Attributes
+	Mode        uint32   // permission bits, see Attributes
+	ContentType string   // MIME type, empty if unknown
+
+	flags uint8
+}
+
+// record flags, stored since version 7
+const (
+	record_deleted    uint8 = 1 << iota // tombstone, the data is dead
+	record_attributes                   // ModTime and Mode were recorded
+)
+
+// Name returns the record file name
+func (r Record) Name() string {
+	return r.FileName
+}
+
+// same_data reports whether r and other hold the same file data. Records
+// without a hash never match.
+func (r Record) same_data(other Record) bool {
+	return r.Hash != [32]byte{} && r.Hash == other.Hash && r.Size == other.Size
+}
+
+// encoded_size is the number of bytes the record takes in the metadata block
+func (r Record) encoded_size() int64 {
+	return binary_size(uint16(0)) + int64(len(r.FileName)) + binary_size(r.Offset) + binary_size(r.Size) + binary_size(r.Checksum) + binary_size(r.flags) + binary_size(r.Compression) + binary_size(r.Stored) + binary_size(r.Hash) + binary_size(r.Nonce) + binary_size(r.ModTime) + binary_size(r.Mode) + binary_size(uint16(0)) + int64(len(r.ContentType))
+}
+
+// write_record writes r as name length, name, offset, size, checksum,
+// flags, compression, stored size, hash, nonce, modification time, mode
+// and content type
+func write_record(w io.Writer, r Record) error {
+	if err := binary.Write(w, binary.LittleEndian, uint16(len(r.FileName))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, r.FileName); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, r.Offset); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, r.Size); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, r.Checksum); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, r.flags); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, r.Compression); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, r.Stored); err != nil {
+		return err
+	}
+	if _, err := w.Write(r.Hash[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(r.Nonce[:]); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, r.ModTime); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, r.Mode); err != nil {
+		return err
+	}
+	return write_string(w, r.ContentType)
+}
+
+// read_record reads a record stored by the given format version
+func read_record(r io.Reader, version uint16) (Record, error) {
+	if version < 3 {
+		return read_legacy_record(r)
+	}
+	var record Record
+	var name_length uint16
+	if err := binary.Read(r, binary.LittleEndian, &name_length); err != nil {
+		return record, fmt.Errorf("Error reading FileName length: %w", err)
+	}
+	name := make([]byte, name_length)
+	if _, err := io.ReadFull(r, name); err != nil {
+		return record, fmt.Errorf("Error reading FileName: %w", err)
+	}
+	record.FileName = string(name)
+	if version >= 5 {
+		if err := binary.Read(r, binary.LittleEndian, &record.Offset); err != nil {
+			return record, fmt.Errorf("Error reading offset: %w", err)
+		}
+	}
+	if err := binary.Read(r, binary.LittleEndian, &record.Size); err != nil {
+		return record, fmt.Errorf("Error reading size: %w", err)
+	}
+	if version >= 4 {
+		if err := binary.Read(r, binary.LittleEndian, &record.Checksum); err != nil {
+			return record, fmt.Errorf("Error reading checksum: %w", err)
+		}
+	}
+	if version >= 7 {
+		if err := binary.Read(r, binary.LittleEndian, &record.flags); err != nil {
+			return record, fmt.Errorf("Error reading flags: %w", err)
+		}
+	}
+	record.Stored = record.Size
+	if version >= 8 {
+		if err := binary.Read(r, binary.LittleEndian, &record.Compression); err != nil {
+			return record, fmt.Errorf("Error reading compression: %w", err)
+		}
+		if err := binary.Read(r, binary.LittleEndian, &record.Stored); err != nil {
+			return record, fmt.Errorf("Error reading stored size: %w", err)
+		}
+	}
+	if version >= 9 {
+		if _, err := io.ReadFull(r, record.Hash[:]); err != nil {
+			return record, fmt.Errorf("Error reading hash: %w", err)
+		}
+	}
+	if version >= 10 {
+		if _, err := io.ReadFull(r, record.Nonce[:]); err != nil {
+			return record, fmt.Errorf("Error reading nonce: %w", err)
+		}
+	}
+	if version >= 11 {
+		if err := binary.Read(r, binary.LittleEndian, &record.ModTime); err != nil {
+			return record, fmt.Errorf("Error reading modification time: %w", err)
+		}
+		if err := binary.Read(r, binary.LittleEndian, &record.Mode); err != nil {
+			return record, fmt.Errorf("Error reading mode: %w", err)
+		}
+		content_type, err := read_string(r)
+		if err != nil {
+			return record, fmt.Errorf("Error reading content type: %w", err)
+		}
+		record.ContentType = content_type
+	}
+	return record, nil
+}
+
+// checksum_reader returns the CRC32C of everything left in r
+func checksum_reader(r io.Reader) (uint32, error) {
+	crc := crc32.New(crc_table)
+	_, err := io.Copy(crc, r)
+	return crc.Sum32(), err
+}
+
+// verify_checksum compares the checksum of data read for record
+func verify_checksum(record Record, sum uint32) error {
+	if sum != record.Checksum {
+		return fmt.Errorf("%w: %s (stored %08x, read %08x)", ErrChecksum, record.FileName, record.Checksum, sum)
+	}
+	return nil
+}
+
+// normalize_name returns the form of name stored in the database.
+// Surrounding white space is dropped, names must be valid UTF-8 without
+// control characters and fit the uint16 length prefix.
+func normalize_name(name string) (string, error) {
+	if !utf8.ValidString(name) {
+		return "", fmt.Errorf("%w: %q is not valid UTF-8", ErrName, name)
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("%w: empty name", ErrName)
+	}
+	if len(name) > math.MaxUint16 {
+		return "", fmt.Errorf("%w: name is longer than %d bytes", ErrName, math.MaxUint16)
+	}
+	if strings.IndexFunc(name, unicode.IsControl) != -1 {
+		return "", fmt.Errorf("%w: %q contains control characters", ErrName, name)
+	}
+	return name, nil
+}
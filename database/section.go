@@ -0,0 +1,188 @@
+package database
+
+import (
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"sync"
+)
+
+// Section returns a reader of the data of the file stored under
+// filename, any range of which can be read without reading the rest.
+// Plain files are read straight from the database or the prefetch buffer
+// holding them, encrypted files a chunk at a time and compressed files
+// are decompressed from their start up to the range, or from the end of
+// the last range read when the range follows it. Once a mutation
+// changes or moves the file the reader fails with ErrChanged.
+// Reads that go through the file in order from its start are checked
+// against its checksum when they reach the end, and fail with
+// ErrChecksum like Get. Other range reads of plain files are not
+// checksummed, encrypted files are authenticated a chunk at a time.
+func (db *DB) Section(filename string) (*io.SectionReader, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	if db.file == nil {
+		return nil, ErrClosed
+	}
+	filename, err := normalize_name(filename)
+	if err != nil {
+		return nil, fmt.Errorf("[READ] %w", err)
+	}
+	index, ok := db.find_record(filename)
+	if !ok {
+		return nil, fmt.Errorf("[READ] %w: %s", ErrNotFound, filename)
+	}
+	record := db.db.Records[index]
+	reader := &record_reader{db: db, record: record, stored: stored_reader{db: db, record: record}, crc: crc32.New(crc_table)}
+	return io.NewSectionReader(reader, 0, reader.record.Size), nil
+}
+
+// record_reader reads ranges of the file data of record. The decompressor
+// of a compressed file is kept between reads so reading in order does
+// not decompress the start again for every range.
+type record_reader struct {
+	db     *DB
+	record Record
+
+	lock   sync.Mutex // serializes the reads of the reader
+	stored stored_reader
+	data   io.ReadCloser // decompressor, nil until a compressed read
+	pos    int64         // offset in the file data has reached
+
+	crc    hash.Hash32 // of the file read in order from its start
+	summed int64       // bytes in crc
+}
+
+func (r *record_reader) ReadAt(p []byte, off int64) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.db.lock.RLock()
+	defer r.db.lock.RUnlock()
+	if r.db.file == nil {
+		return 0, ErrClosed
+	}
+	index, ok := r.db.find_record(r.record.FileName)
+	if !ok || r.db.db.Records[index] != r.record {
+		return 0, fmt.Errorf("[READ] %w: %s", ErrChanged, r.record.FileName)
+	}
+
+	stored := &r.stored
+	stored.missed = false
+	var n int
+	var err error
+	switch {
+	case r.record.Compression != CompressNone:
+		n, err = r.read_compressed(p, off)
+	case r.db.crypt != nil:
+		n, err = r.read_sealed(stored, p, off)
+	default:
+		n, err = stored.ReadAt(p, off)
+	}
+	r.db.count_read(!stored.missed)
+	if err != nil && err != io.EOF {
+		return n, err
+	}
+	// a read going on from the bytes summed extends the sum, the
+	// last byte of the file checks it
+	if end := off + int64(n); off <= r.summed && r.summed < end {
+		r.crc.Write(p[r.summed-off : n])
+		r.summed = end
+		if end == r.record.Size {
+			if sum_err := verify_checksum(r.record, r.crc.Sum32()); sum_err != nil {
+				return n, fmt.Errorf("[READ] %w", sum_err)
+			}
+		}
+	}
+	return n, err
+}
+
+// read_sealed opens only the chunks holding the range
+func (r *record_reader) read_sealed(stored *stored_reader, p []byte, off int64) (int, error) {
+	plain_chunk := int64(crypt_chunk)
+	sealed_chunk := plain_chunk + int64(r.db.crypt.aead.Overhead())
+	n := 0
+	for n < len(p) && off+int64(n) < r.record.Size {
+		pos := off + int64(n)
+		index := pos / plain_chunk
+		sealed := make([]byte, min(sealed_chunk, r.record.Stored-index*sealed_chunk))
+		if _, err := stored.ReadAt(sealed, index*sealed_chunk); err != nil {
+			return n, err
+		}
+		last := index*sealed_chunk+int64(len(sealed)) == r.record.Stored
+		plain, err := r.db.crypt.open_chunk(r.record, uint64(index), sealed, last)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], plain[pos-index*plain_chunk:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// read_compressed decompresses the file up to the range, flate can't
+// start anywhere else. A range at or after the end of the last one
+// resumes the decompressor, one before it starts a new one.
+func (r *record_reader) read_compressed(p []byte, off int64) (int, error) {
+	if r.data != nil && off < r.pos {
+		r.data.Close()
+		r.data = nil
+	}
+	if r.data == nil {
+		sealed := io.NewSectionReader(&r.stored, 0, r.record.Stored)
+		data, err := decompress_reader(r.db.crypt.open_reader(sealed, r.record), r.record)
+		if err != nil {
+			return 0, err
+		}
+		r.data, r.pos = data, 0
+	}
+	skipped, err := io.CopyN(io.Discard, r.data, off-r.pos)
+	r.pos += skipped
+	n := 0
+	if err == nil {
+		n, err = io.ReadFull(r.data, p)
+		r.pos += int64(n)
+	}
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	// a decompressor that failed is not resumed
+	if err != nil && err != io.EOF {
+		r.data.Close()
+		r.data = nil
+	}
+	return n, err
+}
+
+// stored_reader reads the stored bytes of record, from the prefetch
+// buffer as far as it holds them. Caller must hold db.lock.
+type stored_reader struct {
+	db     *DB
+	record Record
+	missed bool // some bytes came from the database
+}
+
+func (s *stored_reader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= s.record.Stored {
+		return 0, io.EOF
+	}
+	var eof error
+	if rest := s.record.Stored - off; int64(len(p)) > rest {
+		p, eof = p[:rest], io.EOF
+	}
+	n := 0
+	if buff, _ := s.db.buffered(s.record.FileName); off < int64(len(buff)) {
+		n = copy(p, buff[off:])
+	}
+	if n < len(p) {
+		s.missed = true
+		at := s.record.Offset + off + int64(n)
+		m, err := io.ReadFull(s.db.stored_range(at, int64(len(p)-n)), p[n:])
+		if n += m; err != nil {
+			return n, err
+		}
+	}
+	return n, eof
+}
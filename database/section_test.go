@@ -0,0 +1,62 @@
+package database_test
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"quark/database"
+	"testing"
+)
+
+// TestSectionChecksum flips a byte of the stored data of a plain file. A
+// range away from it reads, reading the file in order from its start
+// fails with ErrChecksum at the end.
+func TestSectionChecksum(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a")
+	if err := os.WriteFile(path, []byte("zzzzzzzzzzzzzzzz"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	db_path := filepath.Join(dir, "test.db")
+	db, err := database.Open(db_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put(path); err != nil {
+		t.Fatal(err)
+	}
+	offset := db.List()[0].Offset
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.OpenFile(db_path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteAt([]byte("Q"), offset+12); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	db, err = database.Open(db_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	section, err := db.Section("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part := make([]byte, 4)
+	if _, err := section.ReadAt(part, 2); err != nil || string(part) != "zzzz" {
+		t.Fatalf("range reads %q, %v", part, err)
+	}
+	if _, err := io.ReadAll(io.LimitReader(section, 8)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(section); !errors.Is(err, database.ErrChecksum) {
+		t.Errorf("reading to the end: %v, want %v", err, database.ErrChecksum)
+	}
+}
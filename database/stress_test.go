@@ -0,0 +1,329 @@
+package database_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"quark/database"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	stress_readers = 8
+	stress_kept    = 12 // files only ever moved by the writer
+	stress_churned = 6  // files the writer puts, renames and deletes
+)
+
+// stress_phase is a database setup the stress test runs against
+type stress_phase struct {
+	name        string
+	passphrase  []byte // nil for a plain database
+	compression database.Compression
+	mmap        bool
+}
+
+// stress_state is shared by the goroutines of a stress phase
+type stress_state struct {
+	db       *database.DB
+	dir      string
+	contents map[string][]byte // of every file by its first name
+	stop     chan struct{}
+	once     sync.Once
+	err      error // the first failure, set before stop is closed
+	reads    atomic.Int64
+	ranges   atomic.Int64
+	changes  atomic.Int64
+}
+
+// TestStress runs readers against a test database while a writer puts,
+// replaces, renames, deletes, reorganises and compacts files under them.
+// Every read must return the file as it was written or fail as the file
+// being gone or changed, and the database must check out and reopen
+// whole afterwards. Meant to be run with the race detector.
+func TestStress(t *testing.T) {
+	duration := 2 * time.Second
+	if testing.Short() {
+		duration = time.Second / 4
+	}
+	phases := []stress_phase{
+		{name: "plain", compression: database.CompressNone},
+		{name: "encrypted flate", passphrase: []byte("stress"), compression: database.CompressFlate},
+		{name: "mmap", compression: database.CompressNone, mmap: true},
+	}
+	for _, phase := range phases {
+		t.Run(phase.name, func(t *testing.T) {
+			stress_run(t, phase, duration)
+		})
+	}
+}
+
+// stress_content makes the data of the test file number ix. Lines are
+// numbered so a range read from the wrong place does not match.
+func stress_content(name string, ix int) []byte {
+	size := (ix%4 + 1) * 96 * 1024
+	var data []byte
+	for line := 0; len(data) < size; line++ {
+		data = fmt.Appendf(data, "%s line %d\n", name, line)
+	}
+	return data[:size]
+}
+
+func stress_open(phase stress_phase, path string) (*database.DB, error) {
+	if phase.passphrase == nil {
+		return database.Open(path)
+	}
+	return database.OpenEncrypted(path, phase.passphrase)
+}
+
+// stress_run runs one phase for duration
+func stress_run(t *testing.T, phase stress_phase, duration time.Duration) {
+	dir := t.TempDir()
+
+	var kept, churned []string
+	for i := 0; i < stress_kept; i++ {
+		kept = append(kept, fmt.Sprintf("kept-%02d", i))
+	}
+	for i := 0; i < stress_churned; i++ {
+		churned = append(churned, fmt.Sprintf("churned-%02d", i))
+	}
+	contents := make(map[string][]byte)
+	for ix, name := range append(slices.Clone(kept), churned...) {
+		contents[name] = stress_content(name, ix)
+		if err := os.WriteFile(filepath.Join(dir, name), contents[name], 0644); err != nil {
+			t.Fatalf("Can't create test file: %s", err)
+		}
+	}
+
+	path := filepath.Join(dir, "stress.db")
+	db, err := stress_open(phase, path)
+	if err != nil {
+		t.Fatalf("Can't create database: %s", err)
+	}
+	for _, name := range kept {
+		if err := db.PutCompressed(filepath.Join(dir, name), phase.compression); err != nil {
+			db.Close()
+			t.Fatalf("Can't write test file: %s", err)
+		}
+	}
+	if phase.mmap {
+		if err := db.SetMmap(true); errors.Is(err, database.ErrMmap) {
+			t.Logf("%s, reading the file", err)
+		} else if err != nil {
+			db.Close()
+			t.Fatal(err)
+		}
+	}
+	// every read queues the next kept file for the prefetcher
+	var table []database.EFilePair
+	for ix, name := range kept {
+		next := kept[(ix+1)%len(kept)]
+		table = append(table, database.EFilePair{Fname: name, Info: database.EFileInfo{TotalWeight: 1, MaxEdges: []string{next}}})
+	}
+	db.SetPrefetch(true, table)
+
+	state := &stress_state{db: db, dir: dir, contents: contents, stop: make(chan struct{})}
+	var wg sync.WaitGroup
+	for i := 0; i < stress_readers; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			state.reader(rand.New(rand.NewSource(seed)), kept, churned)
+		}(int64(i))
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		state.writer(phase, churned)
+	}()
+	select {
+	case <-time.After(duration):
+	case <-state.stop:
+	}
+	state.halt(nil)
+	wg.Wait()
+	if state.err != nil {
+		db.Close()
+		t.Fatal(state.err)
+	}
+
+	report, err := db.Check()
+	if err == nil && !report.OK() {
+		err = fmt.Errorf("check found %d problems: %s", len(report.Problems), report.Problems[0].Detail)
+	}
+	hits, _ := db.CacheStats()
+	if close_err := db.Close(); err == nil {
+		err = close_err
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stress_verify(phase, path, kept, contents); err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("%d reads and %d range reads by %d readers, %d mutations, %d prefetch hits",
+		state.reads.Load(), state.ranges.Load(), stress_readers, state.changes.Load(), hits)
+}
+
+// halt stops the phase with err as its failure if it is the first
+func (s *stress_state) halt(err error) {
+	s.once.Do(func() {
+		s.err = err
+		close(s.stop)
+	})
+}
+
+func (s *stress_state) stopped() bool {
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// reader reads whole files and ranges of them until the phase stops.
+// Kept files are always there, churned ones may be gone.
+func (s *stress_state) reader(rnd *rand.Rand, kept []string, churned []string) {
+	for !s.stopped() {
+		name := kept[rnd.Intn(len(kept))]
+		optional := rnd.Intn(3) == 0
+		if optional {
+			name = churned[rnd.Intn(len(churned))]
+		}
+		want := s.contents[name]
+
+		var got bytes.Buffer
+		err := s.db.Get(name, &got)
+		if optional && errors.Is(err, database.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			s.halt(fmt.Errorf("reading %s: %w", name, err))
+			return
+		}
+		if !bytes.Equal(got.Bytes(), want) {
+			s.halt(fmt.Errorf("reading %s: got %d bytes that differ from the %d written", name, got.Len(), len(want)))
+			return
+		}
+		s.reads.Add(1)
+
+		// a reader of a file moved by the writer fails with ErrChanged
+		section, err := s.db.Section(name)
+		if optional && errors.Is(err, database.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			s.halt(fmt.Errorf("opening %s: %w", name, err))
+			return
+		}
+		off := rnd.Int63n(int64(len(want)))
+		buf := make([]byte, min(int64(rnd.Intn(256*1024)+1), int64(len(want))-off))
+		if _, err := section.ReadAt(buf, off); errors.Is(err, database.ErrChanged) {
+			continue
+		} else if err != nil && err != io.EOF {
+			s.halt(fmt.Errorf("reading %s at %d: %w", name, off, err))
+			return
+		}
+		if !bytes.Equal(buf, want[off:off+int64(len(buf))]) {
+			s.halt(fmt.Errorf("reading %s at %d: %d bytes differ from the ones written", name, off, len(buf)))
+			return
+		}
+		s.ranges.Add(1)
+
+		if entries, err := s.db.ListDir(""); err != nil || len(entries) < len(kept) {
+			s.halt(fmt.Errorf("listing: %d entries for at least %d files: %v", len(entries), len(kept), err))
+			return
+		}
+	}
+}
+
+// writer mutates the database until the phase stops. Each churned file
+// in turn is put, renamed and back, replaced and deleted, with the whole
+// database reorganised, compacted and checked in between.
+func (s *stress_state) writer(phase stress_phase, churned []string) {
+	for step := 0; !s.stopped(); step++ {
+		name := churned[step%len(churned)]
+		path := filepath.Join(s.dir, name)
+		var err error
+		switch turn := step / len(churned); turn % 4 {
+		case 0:
+			// every other round the file goes first, rewriting the rest
+			if turn%8 == 0 {
+				err = s.db.PutCompressed(path, phase.compression)
+			} else {
+				err = s.db.PutAtCompressed(path, 0, phase.compression)
+			}
+		case 1:
+			if err = s.db.Rename(name, name+".moved"); err == nil {
+				err = s.db.Rename(name+".moved", name)
+			}
+		case 2:
+			err = s.db.Replace(name, path)
+		case 3:
+			err = s.db.Delete(name)
+		}
+		if err != nil {
+			s.halt(fmt.Errorf("writer at step %d on %s: %w", step, name, err))
+			return
+		}
+		s.changes.Add(1)
+
+		switch step % 7 {
+		case 3:
+			var order []string
+			for _, record := range s.db.List() {
+				order = append(order, record.Name())
+			}
+			slices.Reverse(order)
+			err = s.db.Reorganise(order)
+		case 5:
+			err = s.db.Compact()
+		case 6:
+			var report database.Report
+			if report, err = s.db.Check(); err == nil && !report.OK() {
+				err = fmt.Errorf("check found %d problems: %s", len(report.Problems), report.Problems[0].Detail)
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			s.halt(fmt.Errorf("writer at step %d: %w", step, err))
+			return
+		}
+		s.changes.Add(1)
+	}
+}
+
+// stress_verify reopens the database at path and reads every file back
+func stress_verify(phase stress_phase, path string, kept []string, contents map[string][]byte) error {
+	db, err := stress_open(phase, path)
+	if err != nil {
+		return fmt.Errorf("Can't reopen database: %w", err)
+	}
+	defer db.Close()
+	names := make(map[string]bool)
+	for _, record := range db.List() {
+		names[record.Name()] = true
+		var got bytes.Buffer
+		if err := db.Get(record.Name(), &got); err != nil {
+			return fmt.Errorf("reading %s after reopening: %w", record.Name(), err)
+		}
+		if !bytes.Equal(got.Bytes(), contents[record.Name()]) {
+			return fmt.Errorf("reading %s after reopening: data differs from the one written", record.Name())
+		}
+	}
+	for _, name := range kept {
+		if !names[name] {
+			return fmt.Errorf("%s is gone after reopening", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package database
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ImportTar stores the regular files of the tar archive read from r after
+// the files already stored, with the names, modes and modification times
+// of the archive. Their data is streamed past the end of the database as
+// the archive is read and the metadata is updated once at the end, so
+// the archive goes in entirely or not at all. Returns the names imported.
+func (db *DB) ImportTar(r io.Reader) ([]string, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	if db.file == nil {
+		return nil, ErrClosed
+	}
+	archive := tar.NewReader(r)
+	return db.import_entries("FROMTAR", func() (archive_entry, error) {
+		header, err := archive.Next()
+		if err != nil {
+			return archive_entry{}, err
+		}
+		return archive_entry{
+			name:        header.Name,
+			info:        header.FileInfo(),
+			size:        header.Size,
+			compression: db.header.Compression,
+			body:        io.NopCloser(archive),
+		}, nil
+	})
+}
+
+// ExportTar writes every file to w as a tar archive with its recorded
+// mode and modification time. Files follow the layout of the database,
+// so it is read front to back.
+func (db *DB) ExportTar(w io.Writer) error {
+	db.cold_read_request.Store(true)
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	db.cold_read_request.Store(false)
+	if db.file == nil {
+		return ErrClosed
+	}
+	return db.export_tar(w)
+}
+
+// export_tar writes the files in layout order, files without attributes
+// get the usual mode. Caller must hold db.lock.
+func (db *DB) export_tar(w io.Writer) error {
+	archive := tar.NewWriter(w)
+	for _, record := range db.layout() {
+		// PAX keeps the modification time to the nanosecond
+		entry := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     record.FileName,
+			Size:     record.Size,
+			Mode:     0644,
+			ModTime:  time.Unix(0, 0),
+			Format:   tar.FormatPAX,
+		}
+		if modified, mode, ok := record.Attributes(); ok {
+			entry.Mode, entry.ModTime = int64(mode), modified
+		}
+		if err := archive.WriteHeader(entry); err != nil {
+			return fmt.Errorf("[TOTAR] Failed to write %s: %w", record.FileName, err)
+		}
+		if err := db.read(record.FileName, archive); err != nil {
+			return fmt.Errorf("[TOTAR] %w", err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return fmt.Errorf("[TOTAR] Failed to write the archive: %w", err)
+	}
+	return nil
+}
@@ -1,13 +1,12 @@
-package main
+package database
 
 import (
-	"bytes"
 	"encoding/binary"
 	"encoding/csv"
 	"fmt"
 	"io"
-	"log"
 	"os"
+	"path/filepath"
 	"strconv"
 )
 
@@ -15,14 +14,6 @@ func logfilename(filename string) string {
 	return fmt.Sprintf("%s.csv", filename)
 }
 
-func move_cursor(data any) {
-	size := binary.Size(data)
-	if size == -1 {
-		return
-	}
-	cursor_position += int64(size)
-}
-
 func binary_size(data any) int64 {
 	size := binary.Size(data)
 	if size == -1 {
@@ -31,34 +22,6 @@ func binary_size(data any) int64 {
 	return int64(size)
 }
 
-func truncateString(s string) [40]byte {
-	// Convert the string to a byte slice
-	stringBytes := []byte(s)
-
-	// Create a fixed-size byte array of length 40
-	var fixedSizeByteArray [40]byte
-
-	// Copy the content of the byte slice into the fixed-size byte array
-	copy(fixedSizeByteArray[:], stringBytes)
-
-	return fixedSizeByteArray
-}
-func byteReadable(b [40]byte) string {
-	return string(bytes.TrimRight(b[:], "\x00"))
-}
-
-func record_name_compare(record_filename [40]byte, target_filename string) bool {
-	s_record_filename := string(bytes.TrimRight(record_filename[:], "\x00"))
-	return s_record_filename == target_filename
-}
-
-func record_contains(db *DatabaseStructure, filename string) bool {
-	for _, v := range db.Records {
-		return record_name_compare(v.FileName, filename)
-	}
-	return false
-}
-
 func string_contains(slice []string, value string) bool {
 	for _, item := range slice {
 		if item == value {
@@ -71,7 +34,6 @@ func string_contains(slice []string, value string) bool {
 func read_readlog(csvPath string) []Readlog {
 	file, err := os.OpenFile(csvPath, os.O_RDONLY, 0644)
 	if err != nil {
-		fmt.Println("[READLG] No log file:", err)
 		return nil
 	}
 	defer file.Close()
@@ -79,7 +41,6 @@ func read_readlog(csvPath string) []Readlog {
 
 	_, err = reader.Read()
 	if err != nil {
-		fmt.Println("[READLG] Reader can't read:", err)
 		return nil
 	}
 
@@ -90,11 +51,11 @@ func read_readlog(csvPath string) []Readlog {
 			break
 		}
 		if err != nil {
-			log.Fatal(err)
+			return nil
 		}
-		time, err2 := strconv.ParseInt(raw_record[1], 10, 64)
-		if err2 != nil {
-			log.Fatal(err)
+		time, err := strconv.ParseInt(raw_record[1], 10, 64)
+		if err != nil {
+			return nil
 		}
 		records = append(records, Readlog{
 			FileName: raw_record[0],
@@ -104,26 +65,50 @@ func read_readlog(csvPath string) []Readlog {
 	return records
 }
 
-func create_file(filepath_db string) *os.File {
-	file, err := os.Create(filepath_db)
+// create_file creates an empty database at filepath_db with header and
+// its metadata sealed with c. It is built
+// under a temporary name and renamed, so a crash never leaves half a
+// header behind.
+func create_file(filepath_db string, header Header, c *crypt) (*os.File, error) {
+	file, err := os.CreateTemp(filepath.Dir(filepath_db), filepath.Base(filepath_db)+".tmp*")
 	if err != nil {
-		log.Fatal("[MAIN] Error creating database: ", err)
+		return nil, fmt.Errorf("[OPEN] Error creating database: %w", err)
+	}
+	fail := func(err error) (*os.File, error) {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, fmt.Errorf("[OPEN] Error writing to database: %w", err)
 	}
 
-	// First Byte
-	var first_byte uint8 = 0
-	err = binary.Write(file, binary.LittleEndian, first_byte)
+	// Header, Record Count and room for the metadata to grow
+	header.DataStart = int64(header.HeaderLength) + metadata_capacity(metadata_size(nil)+c.metadata_overhead())
+	if err := write_header(file, header); err != nil {
+		return fail(err)
+	}
+	metadata, err := encode_records(nil, nil, c)
 	if err != nil {
-		log.Fatal("[MAIN] Error writing to database: ", err)
+		return fail(err)
 	}
-	// move cursor_position to first_byte
-	cursor_position += binary_size(first_byte)
-	return file
-}
-
-type QueueRecord struct {
-	FileName string
-	SizeRead int64
+	if _, err := file.Write(metadata); err != nil {
+		return fail(err)
+	}
+	if err := file.Truncate(header.DataStart); err != nil {
+		return fail(err)
+	}
+	if err := file.Chmod(0644); err != nil {
+		return fail(err)
+	}
+	if err := file.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := os.Rename(file.Name(), filepath_db); err != nil {
+		return fail(err)
+	}
+	if err := sync_dir(filepath_db); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("[OPEN] Error creating database: %w", err)
+	}
+	return file, nil
 }
 
 type Queue[T any] interface {
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ImportZip stores the regular files of the zip archive in r of the given
+// size after the files already stored, with the names, modes and
+// modification times of the archive. Deflated files are stored with
+// flate and stored ones as they are. Like ImportTar the archive goes in
+// entirely or not at all. Returns the names imported.
+func (db *DB) ImportZip(r io.ReaderAt, size int64) ([]string, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	if db.file == nil {
+		return nil, ErrClosed
+	}
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("[IMPORT] Failed to read the archive: %w", err)
+	}
+	files := archive.File
+	return db.import_entries("IMPORT", func() (archive_entry, error) {
+		if len(files) == 0 {
+			return archive_entry{}, io.EOF
+		}
+		file := files[0]
+		files = files[1:]
+		body, err := file.Open()
+		if err != nil {
+			return archive_entry{}, err
+		}
+		entry := archive_entry{
+			name:        file.Name,
+			info:        file.FileInfo(),
+			size:        int64(file.UncompressedSize64),
+			compression: CompressNone,
+			body:        body,
+		}
+		if file.Method == zip.Deflate {
+			entry.compression = CompressFlate
+		}
+		return entry, nil
+	})
+}
+
+// ExportZip writes every file to w as a zip archive with its recorded
+// mode and modification time, deflated if it is stored with flate and
+// stored otherwise. Files follow the layout of the database.
+func (db *DB) ExportZip(w io.Writer) error {
+	db.cold_read_request.Store(true)
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	db.cold_read_request.Store(false)
+	if db.file == nil {
+		return ErrClosed
+	}
+	return db.export_zip(w)
+}
+
+// export_zip writes the files in layout order, files without attributes
+// get the usual mode. Caller must hold db.lock.
+func (db *DB) export_zip(w io.Writer) error {
+	archive := zip.NewWriter(w)
+	for _, record := range db.layout() {
+		entry := &zip.FileHeader{
+			Name:     record.FileName,
+			Method:   zip.Store,
+			Modified: time.Unix(0, 0),
+		}
+		entry.SetMode(0644)
+		if record.Compression == CompressFlate {
+			entry.Method = zip.Deflate
+		}
+		if modified, mode, ok := record.Attributes(); ok {
+			entry.Modified = modified
+			entry.SetMode(mode)
+		}
+		file, err := archive.CreateHeader(entry)
+		if err != nil {
+			return fmt.Errorf("[EXPORT] Failed to write %s: %w", record.FileName, err)
+		}
+		if err := db.read(record.FileName, file); err != nil {
+			return fmt.Errorf("[EXPORT] %w", err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return fmt.Errorf("[EXPORT] Failed to write the archive: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"quark/database"
+)
+
+// passphrase_env holds the passphrase so it isn't prompted for
+const passphrase_env = "QUARK_PASSPHRASE"
+
+var encrypt_flag = flag.Bool("encrypt", false, "create the database encrypted")
+
+// stdin_taken is set when stdin carries data, the passphrase is then
+// prompted for on the terminal
+var stdin_taken bool
+
+// open_database opens the database at path, getting the passphrase if
+// it is encrypted or -encrypt creates it
+func open_database(path string) (*database.DB, error) {
+	encrypted, err := database.Encrypted(path)
+	creating := os.IsNotExist(err)
+	if creating {
+		encrypted, err = *encrypt_flag, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !encrypted {
+		return database.Open(path)
+	}
+	passphrase, err := get_passphrase(creating)
+	if err != nil {
+		return nil, err
+	}
+	return database.OpenEncrypted(path, passphrase)
+}
+
+// check_database checks the database at path, getting the passphrase if
+// it is encrypted
+func check_database(path string) (database.Report, error) {
+	encrypted, err := database.Encrypted(path)
+	if err != nil || !encrypted {
+		return database.Check(path)
+	}
+	passphrase, err := get_passphrase(false)
+	if err != nil {
+		return database.Report{Path: path}, err
+	}
+	return database.CheckEncrypted(path, passphrase)
+}
+
+// get_passphrase reads the passphrase from QUARK_PASSPHRASE or prompts
+// for it, twice when a new database is created, which needs one that
+// isn't empty
+func get_passphrase(confirm bool) ([]byte, error) {
+	if passphrase, ok := os.LookupEnv(passphrase_env); ok {
+		if confirm && passphrase == "" {
+			return nil, fmt.Errorf("%w, %s is set but empty", database.ErrEmptyKey, passphrase_env)
+		}
+		return []byte(passphrase), nil
+	}
+	passphrase, err := prompt_passphrase("Passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if confirm && len(passphrase) == 0 {
+		return nil, database.ErrEmptyKey
+	}
+	if confirm {
+		again, err := prompt_passphrase("Repeat passphrase: ")
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(passphrase, again) {
+			return nil, errors.New("passphrases do not match")
+		}
+	}
+	return passphrase, nil
+}
+
+// prompt_passphrase asks for a line on stdin, or the terminal when
+// stdin is taken, with echo turned off. It reads byte by byte so nothing
+// meant for the prompt after it is taken.
+func prompt_passphrase(prompt string) ([]byte, error) {
+	input := os.Stdin
+	if stdin_taken {
+		tty, err := os.Open("/dev/tty")
+		if err != nil {
+			return nil, fmt.Errorf("Can't prompt for the passphrase, stdin is taken and there is no terminal, set %s: %w", passphrase_env, err)
+		}
+		defer tty.Close()
+		input = tty
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	if stty(input, "-echo") == nil {
+		defer func() {
+			stty(input, "echo")
+			fmt.Fprintln(os.Stderr)
+		}()
+	}
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := input.Read(b)
+		if n == 1 && b[0] == '\n' {
+			break
+		}
+		if n == 1 {
+			line = append(line, b[0])
+		}
+		if err != nil {
+			if len(line) > 0 {
+				break
+			}
+			return nil, fmt.Errorf("Can't read passphrase: %w", err)
+		}
+	}
+	return bytes.TrimSuffix(line, []byte{'\r'}), nil
+}
+
+// stty changes the terminal on input, failing when it isn't one
+func stty(input *os.File, arg string) error {
+	cmd := exec.Command("stty", arg)
+	cmd.Stdin = input
+	return cmd.Run()
+}
@@ -3,78 +3,27 @@ package main
 import (
 	"bufio"
 	"bytes"
-	"encoding/binary"
-	"encoding/csv"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"quark/database"
 	"runtime/debug"
-	"sort"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 )
 
-/*
-File Structure:
-    Record Count - uint8
-    Records:
-        filename - [40]byte
-        size 	 - int64
-    Files:
-        file 	 - any size
-----------------------------------------
-test.bin =>
-	total_record_count,
-	records[file_name, file_size],
-	record_data
-*/
-
-type Record struct {
-	FileName [40]byte // [40]byte
-	Size     int64
-}
-
-type DatabaseStructure struct {
-	RecordCount uint8
-	Records     []Record
-}
-
-type Readlog struct {
-	FileName string
-	Time     int64
-}
-
-type FileMap map[string]EFileInfo // from filename to maximum edge
-
-type EFilePair struct {
-	Fname string
-	Info  EFileInfo
-}
-
-type EFileInfo struct {
-	TotalWeight int
-	MaxEdges    []string
-}
-
-// GLOBAL TYPES
-var cursor_position int64 = 0
-var last_fileinfo []EFilePair
-var opt2_flag bool = false
-var file_buffer_map = make(map[string]*bytes.Buffer)
-var IdleQueue = NewSliceQueue[QueueRecord]()
-
-var DATABASE_LOCK sync.Mutex = sync.Mutex{}
+var mmap_flag = flag.Bool("mmap", false, "read through a memory mapping, time compares it with seek reads")
 
 func main() {
 	flag.Parse()
 	//	Database first argument error check
 	if flag.NArg() < 1 {
-		log.Fatal("Usage: quark <database.db>")
+		log.Fatal("Usage: quark [-encrypt] [-mmap] <database.db>")
 	}
 
 	filepath_db := flag.Arg(0)
@@ -86,98 +35,265 @@ func main() {
 		if flag.NArg() == 3 {
 			n, err = strconv.Atoi(flag.Arg(2))
 			if err != nil {
-				log.Fatal("Usage: quark time <file> <times>", err)
+				log.Fatal("Usage: quark [-mmap] time <file> <times>", err)
 			}
 		}
-		timed_execute(opt_file, n)
+		timed_execute(opt_file, n, *mmap_flag)
 		return
 	}
+	if filepath_db == "fsck" {
+		if flag.NArg() != 2 {
+			log.Fatal("Usage: quark fsck <database.db>")
+		}
+		os.Exit(fsck(filepath.Clean(flag.Arg(1))))
+	}
+	if filepath_db == "fromtar" {
+		if flag.NArg() != 3 {
+			log.Fatal("Usage: quark [-encrypt] fromtar <archive.tar|-> <database.db>")
+		}
+		os.Exit(from_tar(flag.Arg(1), flag.Arg(2)))
+	}
+	if filepath_db == "totar" {
+		if flag.NArg() != 3 {
+			log.Fatal("Usage: quark totar <database.db> <archive.tar|->")
+		}
+		os.Exit(to_tar(flag.Arg(1), flag.Arg(2)))
+	}
 	filepath_db = filepath.Clean(filepath_db)
 
-	db_structure := DatabaseStructure{
-		RecordCount: 0,
-		Records:     []Record{},
-	}
-	if _, err := os.Stat(filepath_db); os.IsNotExist(err) {
-		// IF NOT EXIST
+	if _, err := os.Stat(filepath_db); os.IsNotExist(err) && *encrypt_flag {
+		fmt.Printf("[MAIN] Creating an encrypted database file '%s'\n", filepath_db)
+	} else if os.IsNotExist(err) {
 		fmt.Printf("[MAIN] Creating a database file '%s'\n", filepath_db)
-		file := create_file(filepath_db)
-		// start the repl
-		repl(file, &db_structure)
-	} else if err != nil {
-		log.Fatal(err)
 	} else {
-		//IF EXIST
 		fmt.Printf("[MAIN] Reading the database file %q\n", filepath_db)
-		//File open with read-write permissions
-		file, err := os.OpenFile(filepath_db, os.O_RDWR, os.ModePerm)
+	}
+	db, err := open_database(filepath_db)
+	if err != nil {
+		log.Fatal("[MAIN] ", err)
+	}
+	if recovery, ok := db.Recovered(); ok {
+		print_recovery(recovery)
+	}
+	if *mmap_flag {
+		if err := db.SetMmap(true); err != nil {
+			fmt.Println(err)
+		}
+	}
+	records := db.List()
+	if len(records) > 0 {
+		fmt.Printf("[MAIN] %s has %d files\n", filepath_db, len(records))
+		print_dbstat(records)
+	}
+
+	repl(db)
+}
+
+func print_dbstat(records []database.Record) {
+	fmt.Println("----------------------")
+	fmt.Println("ORD  Filename  Size  Stored  Mode  Modified")
+	for ix, val := range records {
+		stored := format_size(val.Stored)
+		if val.Compression != database.CompressNone {
+			stored += " " + val.Compression.String()
+		}
+		if modified, mode, ok := val.Attributes(); ok {
+			fmt.Printf("%-3d | %s | %s | %s | %s | %s\n", ix, val.Name(), format_size(val.Size), stored, mode, modified.Format(time.DateTime))
+		} else {
+			fmt.Printf("%-3d | %s | %s | %s\n", ix, val.Name(), format_size(val.Size), stored)
+		}
+	}
+	fmt.Println("----------------------")
+}
+
+// print_ls lists the directory named by prefix with the totals of each
+// directory, or the files matching prefix if it is a glob
+func print_ls(db *database.DB, prefix string) {
+	var entries []database.Entry
+	if strings.ContainsAny(prefix, `*?[\`) {
+		records, err := db.Glob(prefix)
 		if err != nil {
-			log.Fatal("[MAIN] Error opening database: ", err)
+			fmt.Println(err)
+			return
 		}
-		if err := binary.Read(file, binary.LittleEndian, &db_structure.RecordCount); err != nil {
-			log.Fatal("[MAIN] Error reading first byte: ", err)
+		for _, record := range records {
+			entries = append(entries, database.Entry{Name: record.Name(), Record: record, Files: 1, Size: record.Size, Stored: record.Stored})
 		}
-		move_cursor(&db_structure.RecordCount)
-		//	Read each record
-		for i := 0; i < int(db_structure.RecordCount); i++ {
-			var record Record
-			// Reads filename [40 bytes]
-			if err := binary.Read(file, binary.LittleEndian, &record.FileName); err != nil {
-				log.Fatal("[MAIN] Error reading FileName: ", err)
-			}
-			// reads file size [8 bytes]
-			if err := binary.Read(file, binary.LittleEndian, &record.Size); err != nil {
-				log.Fatal("[MAIN] Error reading size: ", err)
-			}
-			// read records in order and send them to main db
-			db_structure.Records = append(db_structure.Records, record)
+	} else {
+		var err error
+		if entries, err = db.ListDir(prefix); err != nil {
+			fmt.Println(err)
+			return
 		}
-		// move cursor up to total record counts
-		move_cursor(&db_structure.Records)
-		fmt.Printf("[MAIN] %s has %d files and cursor position is at %d\n", file.Name(), db_structure.RecordCount, cursor_position)
-		print_dbstat(&db_structure)
+	}
 
-		repl(file, &db_structure)
+	files, size, stored := 0, int64(0), int64(0)
+	fmt.Println("----------------------")
+	for _, entry := range entries {
+		files += entry.Files
+		size += entry.Size
+		stored += entry.Stored
+		if entry.Dir {
+			fmt.Printf("%s | %d files | %s | %s\n", entry.Name, entry.Files, format_size(entry.Size), format_size(entry.Stored))
+		} else if modified, mode, ok := entry.Record.Attributes(); ok {
+			fmt.Printf("%s | %s | %s | %s | %s\n", entry.Name, format_size(entry.Size), format_size(entry.Stored), mode, modified.Format(time.DateTime))
+		} else {
+			fmt.Printf("%s | %s | %s\n", entry.Name, format_size(entry.Size), format_size(entry.Stored))
+		}
+	}
+	fmt.Println("----------------------")
+	fmt.Printf("%d files, %s stored for %s\n", files, format_size(stored), format_size(size))
+}
 
+// print_info prints everything recorded about the file named filename
+func print_info(db *database.DB, filename string) {
+	for _, record := range db.List() {
+		if record.Name() != filename {
+			continue
+		}
+		fmt.Printf("Name:         %s\n", record.Name())
+		fmt.Printf("Size:         %s (%d bytes)\n", format_size(record.Size), record.Size)
+		fmt.Printf("Stored:       %s, %s\n", format_size(record.Stored), record.Compression)
+		if modified, mode, ok := record.Attributes(); ok {
+			fmt.Printf("Mode:         %s\n", mode)
+			fmt.Printf("Modified:     %s\n", modified.Format(time.RFC3339))
+		}
+		if record.ContentType != "" {
+			fmt.Printf("Content type: %s\n", record.ContentType)
+		}
+		fmt.Printf("Checksum:     %08x\n", record.Checksum)
+		if record.Hash != [32]byte{} {
+			fmt.Printf("SHA-256:      %x\n", record.Hash)
+		}
+		return
 	}
+	fmt.Printf("[INFO] %s: %s\n", database.ErrNotFound, filename)
 }
 
-func print_dbstat(db *DatabaseStructure) {
-	fmt.Println("----------------------")
-	fmt.Println("ORD  Filename  Size")
-	for ix, val := range db.Records {
-		size := val.Size
-		if size > (1024 * 1024) {
-			// Convert size to MB
-			sizeMB := float64(size) / (1024 * 1024)
-			fmt.Printf("%-3d | %s | %.1f MiB\n", ix, val.FileName, sizeMB)
-		} else if size > 1024 {
-			sizeKB := float64(size) / 1024
-			fmt.Printf("%-3d | %s | %.1f KiB\n", ix, val.FileName, sizeKB)
+// fsck checks the database at path and prints the report as JSON.
+// Returns the exit code, 1 if problems were found and 2 if the file
+// could not be checked.
+func fsck(path string) int {
+	report, err := check_database(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if !report.OK() {
+		return 1
+	}
+	return 0
+}
+
+// print_report prints the problems found by check
+func print_report(report database.Report) {
+	for _, problem := range report.Problems {
+		if problem.Record != "" {
+			fmt.Printf("[CHECK] %s %s: %s\n", problem.Kind, problem.Record, problem.Detail)
 		} else {
-			fmt.Printf("%-3d | %s | %d B\n", ix, val.FileName, size)
+			fmt.Printf("[CHECK] %s: %s\n", problem.Kind, problem.Detail)
 		}
 	}
-	fmt.Println("----------------------")
+	fmt.Printf("[CHECK] %d files, %d deleted, %d problems\n", report.Records, report.Deleted, len(report.Problems))
+}
+
+// print_recovery reports the interrupted operation finished on open
+func print_recovery(recovery database.Recovery) {
+	if recovery.Op == "" {
+		fmt.Println("[MAIN] Dropped an incomplete journal, the database was not touched")
+		return
+	}
+	outcome := "rolled back"
+	if recovery.Forward {
+		outcome = "completed"
+	}
+	if recovery.Name != "" {
+		fmt.Printf("[MAIN] Found an interrupted %s of %s, %s\n", recovery.Op, recovery.Name, outcome)
+	} else {
+		fmt.Printf("[MAIN] Found an interrupted %s, %s\n", recovery.Op, outcome)
+	}
+}
+
+// print_usage prints the space taken by the database
+func print_usage(db *database.DB) {
+	usage, err := db.Usage()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("%d files, %s stored for %s, %s saved by dedup, %s dead, %s on disk\n",
+		usage.Files, format_size(usage.LiveBytes), format_size(usage.LogicalBytes), format_size(usage.SharedBytes), format_size(usage.DeadBytes), format_size(usage.Size))
+	if encryption := db.Encryption(); encryption != database.EncryptNone {
+		fmt.Printf("encrypted with %s\n", encryption)
+	}
+	if db.Mmapped() {
+		fmt.Println("reads served from a memory mapping")
+	}
+}
+
+func format_size(size int64) string {
+	if size > (1024 * 1024) {
+		// Convert size to MB
+		sizeMB := float64(size) / (1024 * 1024)
+		return fmt.Sprintf("%.1f MiB", sizeMB)
+	} else if size > 1024 {
+		sizeKB := float64(size) / 1024
+		return fmt.Sprintf("%.1f KiB", sizeKB)
+	}
+	return fmt.Sprintf("%d B", size)
+}
+
+func print_occurance(falgo_pslice []database.EFilePair) {
+	if len(falgo_pslice) < 1 {
+		fmt.Println("[OPT] No algo to build")
+		return
+	}
+	fmt.Println("------")
+	for _, falgo := range falgo_pslice {
+		fmt.Printf("%s (%d) -> %s\n", falgo.Fname, falgo.Info.TotalWeight, falgo.Info.MaxEdges[0])
+	}
+	fmt.Println("------")
 }
 
 func print_help() {
 	fmt.Println("\tread  	 <file>")
 	fmt.Println("\treadio    <file>")
-	fmt.Println("\twrite  	 <file> 	 <order|optional>")
+	fmt.Println("\treadrange <file> <offset> <length>")
+	fmt.Println("\timport    <dir> <+include|-exclude ...|optional>")
+	fmt.Println("\timport    zip <in.zip>")
+	fmt.Println("\texport    zip <out.zip>")
+	fmt.Println("\textract   <file> <dest|optional>")
+	fmt.Println("\textractall <dir>")
+	fmt.Println("\twrite  	 <file> 	 <order|optional> <none|flate|optional>")
+	fmt.Println("\tcompression <none|flate|optional>")
 	fmt.Println("\ttime	     code/<file> <times|optional>")
+	fmt.Println("\treplace	 <file> <name|optional>")
+	fmt.Println("\trename	 <old> <new>")
 	fmt.Println("\tdelete 	 <file>")
+	fmt.Println("\tcompact")
+	fmt.Println("\tcheck")
+	fmt.Println("\tstat")
+	fmt.Println("\tls	 <prefix|glob|optional>")
+	fmt.Println("\tinfo	 <file>")
 	fmt.Println("\toptimize1")
 	fmt.Println("\toptimize2")
 	fmt.Println("\tclose OR exit")
 }
 
-func repl(file *os.File, db *DatabaseStructure) {
+func repl(db *database.DB) {
 	scanner := bufio.NewScanner(os.Stdin)
 ReadLoop:
 	for {
 		fmt.Print("> ")
-		scanner.Scan()
+		if !scanner.Scan() {
+			break ReadLoop
+		}
 		command := scanner.Text()
 
 		if strings.HasPrefix(command, "readio") {
@@ -187,10 +303,93 @@ ReadLoop:
 				fmt.Println("open <filename>")
 				continue ReadLoop
 			}
-			if !read(file, db, args[1], os.Stdout) {
+			if err := db.Get(args[1], os.Stdout); err != nil {
+				fmt.Println(err)
+				continue ReadLoop
+			}
+			log_read(db, args[1]) // log to db.csv
+		} else if strings.HasPrefix(command, "readrange") {
+			args := strings.Split(command, " ")
+			// readrange test.txt 4096 512
+			if len(args) != 4 {
+				fmt.Println("readrange <filename> <offset> <length>")
+				continue ReadLoop
+			}
+			offset, oerr := strconv.ParseInt(args[2], 10, 64)
+			length, lerr := strconv.ParseInt(args[3], 10, 64)
+			if oerr != nil || lerr != nil || offset < 0 || length < 0 {
+				fmt.Println("readrange <filename> <offset> <length>")
+				continue ReadLoop
+			}
+			section, err := db.Section(args[1])
+			if err != nil {
+				fmt.Println(err)
+				continue ReadLoop
+			}
+			if _, err := io.Copy(os.Stdout, io.NewSectionReader(section, offset, length)); err != nil {
+				fmt.Println(err)
+			}
+		} else if strings.HasPrefix(command, "import") {
+			args := strings.Split(command, " ")
+			if len(args) == 3 && args[1] == "zip" {
+				import_zip(db, args[2])
 				continue ReadLoop
 			}
-			write_readLog(os.Args[1], db, args[1]) // log to db.csv
+			if len(args) < 2 {
+				fmt.Println("import <dir> <+include|-exclude ...|optional>")
+				continue ReadLoop
+			}
+			var filter database.Filter
+			for _, pattern := range args[2:] {
+				if strings.HasPrefix(pattern, "+") {
+					filter.Include = append(filter.Include, pattern[1:])
+				} else if strings.HasPrefix(pattern, "-") {
+					filter.Exclude = append(filter.Exclude, pattern[1:])
+				} else {
+					fmt.Println("import <dir> <+include|-exclude ...|optional>")
+					continue ReadLoop
+				}
+			}
+			fmt.Println("[IMPORT] Importing", args[1])
+			names, err := db.Import(args[1], filter)
+			if err != nil {
+				fmt.Println(err)
+				continue ReadLoop
+			}
+			fmt.Printf("[IMPORT] Imported %d files\n", len(names))
+		} else if strings.HasPrefix(command, "export") {
+			args := strings.Split(command, " ")
+			if len(args) != 3 || args[1] != "zip" {
+				fmt.Println("export zip <out.zip>")
+				continue ReadLoop
+			}
+			export_zip(db, args[2])
+		} else if strings.HasPrefix(command, "extractall") {
+			args := strings.Split(command, " ")
+			if len(args) != 2 {
+				fmt.Println("extractall <dir>")
+				continue ReadLoop
+			}
+			if err := db.ExtractAll(args[1], extract_progress()); err != nil {
+				fmt.Println(err)
+				continue ReadLoop
+			}
+			fmt.Println("[EXTRACT] Extract complete")
+		} else if strings.HasPrefix(command, "extract") {
+			args := strings.Split(command, " ")
+			if len(args) != 2 && len(args) != 3 {
+				fmt.Println("extract <file> <dest|optional>")
+				continue ReadLoop
+			}
+			dest := ""
+			if len(args) == 3 {
+				dest = args[2]
+			}
+			if err := db.Extract(args[1], dest, extract_progress()); err != nil {
+				fmt.Println(err)
+				continue ReadLoop
+			}
+			fmt.Println("[EXTRACT] Extract complete")
 		} else if strings.HasPrefix(command, "read") {
 			args := strings.Split(command, " ")
 			if len(args) != 2 {
@@ -202,26 +401,19 @@ ReadLoop:
 			lenbefore := buffer.Len()
 
 			start_opt := time.Now()
-			if !opt2_flag {
-				if !read(file, db, args[1], &buffer) {
-					buffer.Reset()
-					debug.FreeOSMemory()
-					continue ReadLoop
-				}
-			} else {
-				if dur := optimize_algo2(file, db, args[1], &buffer, nil); dur == 0 {
-					buffer.Reset()
-					debug.FreeOSMemory()
-					continue ReadLoop
-				}
+			if err := db.Get(args[1], &buffer); err != nil {
+				fmt.Println(err)
+				buffer.Reset()
+				debug.FreeOSMemory()
+				continue ReadLoop
 			}
 			end_opt := time.Now()
 			dur_opt := end_opt.Sub(start_opt)
 
-			write_readLog(os.Args[1], db, args[1])
+			log_read(db, args[1])
 			var file_size int64
-			for _, rec := range db.Records {
-				if byteReadable(rec.FileName) == args[1] {
+			for _, rec := range db.List() {
+				if rec.Name() == args[1] {
 					file_size = rec.Size
 				}
 			}
@@ -233,47 +425,153 @@ ReadLoop:
 			debug.FreeOSMemory()
 		} else if strings.HasPrefix(command, "write") {
 			args := strings.Split(command, " ")
-			// write test.txt or write test.txt 3
-			var order uint8 = db.RecordCount
-			// place in database records
+			// write test.txt, write test.txt 3 or write test.txt 3 flate
+			compression := db.Compression()
+			if len(args) > 2 {
+				// last argument may be the compression
+				if c, cerr := database.ParseCompression(args[len(args)-1]); cerr == nil {
+					compression = c
+					args = args[:len(args)-1]
+				}
+			}
+			var err error
 			if len(args) == 3 {
 				// 3rd argument is order so convert into int
-				t_ord, err := strconv.Atoi(args[2])
-				if err != nil {
-					fmt.Println("write <filename> <order|optional>")
+				t_ord, aerr := strconv.ParseUint(args[2], 10, 32)
+				if aerr != nil {
+					fmt.Println("write <filename> <order|optional> <none|flate|optional>")
 					continue ReadLoop
 				}
-				order = uint8(t_ord)
-
-			} else if len(args) != 2 {
-				fmt.Println("write <filename> <order|optional>")
+				fmt.Printf("[WRITE] Writing %s at %d\n", args[1], t_ord)
+				err = db.PutAtCompressed(args[1], uint32(t_ord), compression)
+			} else if len(args) == 2 {
+				fmt.Printf("[WRITE] Writing %s\n", args[1])
+				err = db.PutCompressed(args[1], compression)
+			} else {
+				fmt.Println("write <filename> <order|optional> <none|flate|optional>")
 				continue ReadLoop
 			}
-
-			if err := write(file, db, args[1], order); err != nil {
-				log.Fatal(err)
+			if err != nil {
+				fmt.Println(err)
+				continue ReadLoop
 			}
+			fmt.Println("[WRITE] Write complete")
 		} else if strings.HasPrefix(command, "delete") {
 			args := strings.Split(command, " ")
 			if len(args) != 2 {
 				fmt.Println("delete <filename>")
 				continue ReadLoop
 			}
-			core_delete(file, db, args[1])
+			if err := db.Delete(args[1]); err != nil {
+				fmt.Println(err)
+				continue ReadLoop
+			}
+			fmt.Println("[DELETE] Delete complete")
+		} else if strings.HasPrefix(command, "replace") {
+			args := strings.Split(command, " ")
+			if len(args) != 2 && len(args) != 3 {
+				fmt.Println("replace <file> <name|optional>")
+				continue ReadLoop
+			}
+			name := ""
+			if len(args) == 3 {
+				name = args[2]
+			}
+			if err := db.Replace(name, args[1]); err != nil {
+				fmt.Println(err)
+				continue ReadLoop
+			}
+			fmt.Println("[REPLACE] Replace complete")
+		} else if strings.HasPrefix(command, "info") {
+			args := strings.Split(command, " ")
+			if len(args) != 2 {
+				fmt.Println("info <file>")
+				continue ReadLoop
+			}
+			print_info(db, args[1])
+		} else if strings.HasPrefix(command, "rename") {
+			args := strings.Split(command, " ")
+			if len(args) != 3 {
+				fmt.Println("rename <old> <new>")
+				continue ReadLoop
+			}
+			if err := db.Rename(args[1], args[2]); err != nil {
+				fmt.Println(err)
+				continue ReadLoop
+			}
+			fmt.Println("[RENAME] Rename complete")
 		} else if strings.HasPrefix(command, "close") || strings.HasPrefix(command, "exit") {
 			break ReadLoop
+		} else if strings.HasPrefix(command, "compact") {
+			before, err := db.Usage()
+			if err != nil {
+				fmt.Println(err)
+				continue ReadLoop
+			}
+			if err := db.Compact(); err != nil {
+				fmt.Println(err)
+				continue ReadLoop
+			}
+			fmt.Printf("[COMPACT] Reclaimed %s\n", format_size(before.DeadBytes))
+		} else if strings.HasPrefix(command, "compression") {
+			args := strings.Split(command, " ")
+			if len(args) == 1 {
+				fmt.Printf("[COMPRESSION] %s\n", db.Compression())
+				continue ReadLoop
+			}
+			c, err := database.ParseCompression(args[1])
+			if err != nil || len(args) != 2 {
+				fmt.Println("compression <none|flate>")
+				continue ReadLoop
+			}
+			if err := db.SetCompression(c); err != nil {
+				fmt.Println(err)
+				continue ReadLoop
+			}
+			fmt.Printf("[COMPRESSION] New files are stored with %s\n", c)
+		} else if strings.HasPrefix(command, "check") {
+			report, err := db.Check()
+			if err != nil {
+				fmt.Println(err)
+				continue ReadLoop
+			}
+			print_report(report)
+		} else if strings.HasPrefix(command, "ls") {
+			args := strings.Split(command, " ")
+			if len(args) > 2 {
+				fmt.Println("ls <prefix|glob|optional>")
+				continue ReadLoop
+			}
+			prefix := ""
+			if len(args) == 2 {
+				prefix = args[1]
+			}
+			print_ls(db, prefix)
 		} else if strings.HasPrefix(command, "stat") {
-			print_dbstat(db)
-		} else if strings.HasPrefix(command, "optimize1") {
-			optimize_algo1(file, db, get_occurance_slice(db, os.Args[1])) // first opt, get files closer
+			print_dbstat(db.List())
+			print_usage(db)
+		} else if strings.HasPrefix(command, "optimize1") { // first opt, get files closer
+			falgo_pslice := db.Occurrences()
+			print_occurance(falgo_pslice)
+			order, err := db.OptimizeLayout(falgo_pslice)
+			if err != nil {
+				fmt.Println(err)
+				continue ReadLoop
+			}
+			if order != nil {
+				fmt.Printf("%+v\n", order)
+				fmt.Println("[REORG] Reorganise complete")
+				print_dbstat(db.List())
+			}
 		} else if strings.HasPrefix(command, "optimize2") { // second opt, caching next common
-			if !opt2_flag {
-				last_fileinfo = get_occurance_slice(db, os.Args[1])
-				opt2_flag = true
+			if !db.Prefetching() {
+				falgo_pslice := db.Occurrences()
+				print_occurance(falgo_pslice)
+				db.SetPrefetch(true, falgo_pslice)
 				fmt.Println("[REPL] OPT2 turned on")
 			} else {
 				fmt.Println("[REPL] OPT2 turned off")
-				opt2_flag = false
+				db.SetPrefetch(false, nil)
 			}
 
 		} else if strings.HasPrefix(command, "time") { // does a timed test
@@ -291,7 +589,7 @@ ReadLoop:
 				fmt.Println("time <filename> <times|optional>")
 				continue ReadLoop
 			}
-			timed_execute(args[1], times)
+			timed_execute(args[1], times, *mmap_flag)
 		} else if strings.HasPrefix(command, "help") {
 			print_help()
 		} else {
@@ -299,346 +597,38 @@ ReadLoop:
 			print_help()
 		}
 	}
-	file.Close()
+	if err := db.Close(); err != nil {
+		fmt.Println("[MAIN] Error closing database:", err)
+	}
 }
 
-func write_readLog(dbname string, db *DatabaseStructure, filename string) {
-	/* READLOG
-	Writing read order of each read file
-	filename	|	time
-	1.txt		|	181.1µs
-	*/
-	if db.RecordCount == 0 {
-		return
-	}
-	fileCheck := false
+// progress_min is the size from which extracting a file shows progress
+const progress_min = 1 << 20
 
-	for _, record := range db.Records {
-		if record_name_compare(record.FileName, filename) {
-			fileCheck = true
-			break
-		}
-	}
-	if !fileCheck {
-		// if file does not exist, exit
-		return
-	}
-
-	// name of binary file "filename"
-	csvPath := "./logs/" + logfilename(dbname)
-	// name of csv file "./logs/filename.csv"
-	// create logs folder if it doesn't exist
-	_, err := os.Stat("./logs")
-	if os.IsNotExist(err) {
-		// Folder doesn't exist, create it
-		err := os.Mkdir("./logs", 0755)
-		if err != nil {
-			fmt.Println("Error creating folder:", err)
+// extract_progress returns a progress printer for extractions, showing
+// each percent of files of at least progress_min bytes
+func extract_progress() database.Progress {
+	last := -1
+	return func(name string, done int64, total int64) {
+		if total < progress_min {
 			return
 		}
-	}
-
-	_, err_stat := os.Stat(csvPath)
-
-	// Open the CSV file in append mode
-	file, err := os.OpenFile(csvPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
-	if err != nil {
-		log.Fatal("[READLOG] Error opening CSV file:", err)
-		return
-	}
-	defer file.Close()
-
-	// Create a CSV writer
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
-	// Check file's existance
-	if os.IsNotExist(err_stat) {
-		headers := []string{"filename", "time"}
-		if err := writer.Write(headers); err != nil {
-			log.Fatal("Error writing headers to CSV:", err)
+		percent := int(done * 100 / total)
+		if percent == last {
 			return
 		}
-	}
-
-	fileReadTime := time.Now().Unix()
-
-	row := []string{filename, strconv.FormatInt(fileReadTime, 10)}
-
-	if err := writer.Write(row); err != nil {
-		log.Fatal("[READLOG] Error writing row to CSV:", err)
-		return
-	}
-}
-
-func get_occurance_slice(db *DatabaseStructure, binaryName string) []EFilePair {
-	csvPath := "./logs/" + logfilename(binaryName)
-	records := read_readlog(csvPath)
-	if records == nil {
-		return nil // nothing to optimize
-	}
-	falgo_pslice := make([]EFilePair, 0)
-	// init all edges
-	for _, recdb := range db.Records {
-		fnname := byteReadable(recdb.FileName)
-		falgo_pslice = append(falgo_pslice, EFilePair{
-			Fname: fnname,
-			Info:  calculate_occurance(records, fnname),
-		})
-	}
-	// sort them
-	sort.Slice(falgo_pslice, func(i, j int) bool {
-		return falgo_pslice[i].Info.TotalWeight > falgo_pslice[j].Info.TotalWeight
-	})
-	if len(falgo_pslice) < 1 {
-		// TODO: add error log
-		fmt.Printf("[OPT] No algo to build")
-		return nil
-	}
-	fmt.Println("------")
-	for _, falgo := range falgo_pslice {
-		fmt.Printf("%s (%d) -> %s\n", falgo.Fname, falgo.Info.TotalWeight, falgo.Info.MaxEdges[0])
-	}
-	fmt.Println("------")
-	return falgo_pslice
-}
-
-func optimize_algo1(file *os.File, db *DatabaseStructure, falgo_pslice []EFilePair) {
-	if falgo_pslice == nil {
-		return
-	}
-	final_res := make([]string, 0)
-
-	falgo := falgo_pslice[0]
-	final_res = append(final_res, falgo.Fname) // first
-
-	var next_falgo = ""
-	if len(falgo.Info.MaxEdges) > 0 { // second
-		next_falgo = falgo.Info.MaxEdges[0]
-		if next_falgo != "" {
-			final_res = append(final_res, next_falgo)
+		last = percent
+		fmt.Printf("\r[EXTRACT] %s %3d%%", name, percent)
+		if done == total {
+			fmt.Println()
+			last = -1
 		}
 	}
-
-	var new_pairs []string = nil
-MainLoop:
-	for { // rest
-		new_pairs = find_occurance(falgo_pslice, next_falgo)
-		if new_pairs == nil {
-			for _, val := range falgo_pslice {
-				if !string_contains(final_res, val.Fname) {
-					new_pairs = find_occurance(falgo_pslice, val.Fname)
-					final_res = append(final_res, val.Fname)
-					break
-				}
-			}
-			if new_pairs == nil {
-				break MainLoop
-			}
-		}
-		for _, nexter_fname := range new_pairs {
-			if nexter_fname == "" {
-				next_falgo = ""
-				break
-			}
-			if !string_contains(final_res, nexter_fname) {
-				final_res = append(final_res, nexter_fname)
-				next_falgo = nexter_fname
-				break
-			}
-		}
-	}
-
-	n_db := [][40]byte{}
-	fmt.Printf("%+v\n", final_res)
-	for _, value := range final_res {
-		n_db = append(n_db, truncateString(value))
-	}
-	reorg(file, db, n_db)
-}
-
-// read with caching next
-func optimize_algo2(file *os.File, db *DatabaseStructure, filename string, dst io.Writer, falgo_pslice []EFilePair) (dur time.Duration) {
-	if falgo_pslice == nil {
-		falgo_pslice = last_fileinfo
-	}
-	cold_read_request = true
-	start_opt := time.Now()
-	if !read(file, db, filename, dst) {
-		return
-	}
-	end_opt := time.Now()
-	cold_read_request = false
-	//fmt.Printf("read %s\n", filename)
-	if falgo_pslice == nil {
-		return
-	}
-	var next_file = ""
-	for _, val := range falgo_pslice {
-		if filename == val.Fname {
-			next_file = val.Info.MaxEdges[0]
-			break
-		}
-	}
-	if next_file != "" {
-		//fmt.Printf("queued %s\n", next_file)
-		IdleQueue.Enqueue(QueueRecord{FileName: next_file, SizeRead: 0})
-		//go read_next(file, db, next_file)
-	}
-	return end_opt.Sub(start_opt)
-}
-
-var cold_read_request bool = false
-
-const chunkSize = 1048576
-
-func idle_loop(file *os.File, db *DatabaseStructure) {
-	for {
-		if IdleQueue.IsEmpty() || cold_read_request {
-			continue
-		}
-		if DATABASE_LOCK.TryLock() {
-			qitem, _ := IdleQueue.Peek()
-			total_read, file_size := read_next(file, db, qitem.FileName, qitem.SizeRead)
-			if total_read == file_size {
-				IdleQueue.Dequeue()
-			} else {
-				IdleQueue.items[0].SizeRead = total_read
-			}
-			//fmt.Println("Mutex is unlocked")
-			DATABASE_LOCK.Unlock()
-		} else {
-			time.Sleep(time.Second / 10)
-		}
-		//time.Sleep(1 * time.Second) // Sleep for a while before checking again
-	}
-}
-
-func read_next(file *os.File, db *DatabaseStructure, next_file string, sizeread int64) (total_read int64, file_size int64) {
-	// FROM CORE.READ //////////////
-	// fail if we didn't write any files yet
-	if db.RecordCount == 0 {
-		fmt.Println("[NEXT] Database has no files written")
-		return 0, file_size
-	}
-	// calculate the location of file in the database
-	var location int64 = binary_size(Record{})*int64(db.RecordCount) + binary_size(&db.RecordCount)
-	for r_count, record := range db.Records {
-		if record_name_compare(record.FileName, next_file) {
-			file_size = record.Size
-			break
-		}
-		location += record.Size
-		if r_count+1 == int(db.RecordCount) { // fail if you reached end
-			fmt.Println("[NEXT] No such file in database")
-			return 0, file_size
-		}
-	}
-	/////////////////////////////
-	location += sizeread // test this
-	_, err := file.Seek(location, io.SeekStart)
-	if err != nil {
-		fmt.Printf("[NEXT] Error seeking the location: %v", err)
-		return 0, file_size
-	}
-
-	var bytebuff = bytes.NewBuffer([]byte{})
-	if buffy := file_buffer_map[next_file]; buffy != nil {
-		if file_buffer_map[next_file].Len() == int(file_size) {
-			return file_size, file_size
-		}
-	} else {
-		file_buffer_map[next_file] = bytebuff
-	}
-
-	for {
-		lcsize := chunkSize
-		if chunkSize+file_buffer_map[next_file].Len() > int(file_size) {
-			lcsize = int(file_size) - file_buffer_map[next_file].Len()
-		}
-		if lcsize == 0 {
-			break
-		}
-		buf := make([]byte, lcsize)
-
-		n, err := file.Read(buf)
-		if n > 0 {
-			file_buffer_map[next_file].Write(buf[:n])
-			total_read += int64(n)
-		}
-		if err != nil {
-			// test me
-			break
-		}
-		if cold_read_request {
-			//fmt.Printf("Cold read request detected. Added %s - %d to the buffer\n", next_file, file_buffer_map[next_file].Len())
-			return int64(file_buffer_map[next_file].Len()), file_size
-		}
-	}
-
-	if bytebuff.Len() == 0 {
-		return 0, file_size //unsure
-	}
-	//fmt.Printf("Added %s - %d to the buffer\n", next_file, file_buffer_map[next_file].Len())
-	return int64(file_buffer_map[next_file].Len()), file_size
 }
 
-func find_occurance(falgo_pslice []EFilePair, next_falgo string) []string {
-	if next_falgo == "" {
-		return nil
-	}
-	for _, val := range falgo_pslice {
-		if val.Fname != next_falgo {
-			continue
-		}
-		return val.Info.MaxEdges
-	}
-	return nil
-}
-
-func calculate_occurance(records []Readlog, fnname string) EFileInfo {
-	var total_weight = 0
-
-	var weight_map = make(map[string]int)
-	for ir, rec := range records {
-		cur_fname := rec.FileName
-		if cur_fname != fnname {
-			continue
-		}
-		if ir+1 == len(records) {
-			total_weight++
-			break
-		}
-		next_fname := records[ir+1].FileName
-		if cur_fname == next_fname {
-			total_weight++
-			continue
-		}
-		total_weight++
-		weight_map[next_fname] += 1
-	}
-
-	type Pair struct {
-		Key   string
-		Value int
-	}
-	var pairs []Pair
-	for k, v := range weight_map {
-		pairs = append(pairs, Pair{k, v})
-	}
-
-	sort.Slice(pairs, func(i, j int) bool {
-		return pairs[i].Value < pairs[j].Value
-	})
-
-	max_edges := make([]string, 3)
-	for ix, v := range pairs {
-		if ix > 2 {
-			break
-		}
-		max_edges[ix] = v.Key
-	}
-	return EFileInfo{
-		TotalWeight: total_weight,
-		MaxEdges:    max_edges,
+// log_read writes filename to the read log of db, reporting failures
+func log_read(db *database.DB, filename string) {
+	if err := db.LogRead(filename); err != nil {
+		fmt.Println(err)
 	}
 }
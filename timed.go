@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	mrand "math/rand"
+	"os"
+	"quark/database"
+	"runtime/debug"
+	"time"
+)
+
+func timed_execute(filepath string, n int, mmap bool) {
+	// recreate database
+	// clear readlog
+	// read file in filepath
+	// create file up to write
+	// write them
+	// read file to a slice
+	// get OPTIMIZE FLAG, or compare mmap reads with seek reads
+	// n times:
+	// 		start timer
+	// 		read files from slice
+	// 		end timer
+	// get the average time
+	// OPTIMIZE_ALGO()
+	// n times:
+	// 		start timer
+	// 		read files from slice
+	// 		end timer
+	// get the average time
+	// print results
+	db_name := "opt_test.bin"
+	os.Remove(db_name)
+
+	var avg_cache_hits, avg_cache_misses int
+
+	db, err := database.Open(db_name)
+	if err != nil {
+		fmt.Printf("[TIMED] Can't create database: %s\n", err)
+		return
+	}
+	defer os.Remove(db_name)
+	defer db.Close()
+	db.ClearReadLog()
+
+	code_file, err2 := os.OpenFile(filepath, os.O_RDONLY, 0644)
+	if err2 != nil {
+		fmt.Printf("[TIMED] No code file: %s\n", err2)
+		return
+	}
+	defer code_file.Close()
+
+	var to_write = make([]string, 0)
+	var to_read = make([]string, 0)
+
+	scanner := bufio.NewScanner(code_file)
+	var flag = 0
+	var opt_state = 0
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		if line == "WRITE" {
+			flag = 1
+			continue
+		} else if line == "OPTIMIZE1" { // 1 -> Frequent-Neighbours
+			opt_state = 1
+			break
+		} else if line == "OPTIMIZE2" { // 2 -> Next-Potential-Caching
+			opt_state = 2
+			break
+		} else if line == "OPTIMIZE3" { // 3 -> Markov-Chain-Caching
+			opt_state = 3
+			fmt.Printf("NOT IMPLEMENTED YET")
+			return
+		} else if line == "OPTIMIZE" { // 4 -> ALL
+			opt_state = 4
+			fmt.Printf("NOT IMPLEMENTED YET")
+			return
+		}
+
+		if flag == 0 {
+			to_write = append(to_write, line)
+		} else if flag == 1 {
+			to_read = append(to_read, line)
+		}
+	}
+	if len(to_read) < 1 || len(to_write) < 1 {
+		fmt.Println("[TIMED] code file is invalid")
+		return
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Printf("[TIMED] Error scanning file: %s\n", err)
+		return
+	}
+
+	for _, fpath := range to_write {
+		fileSize := 100 * 1024 * 1024 // 100mb
+
+		f, err := os.Create(fpath)
+		if err != nil {
+			panic(err)
+		}
+		// Write the random data to the file
+		written := 0
+		for written < fileSize {
+			fbuffer := make([]byte, 4096) // Buffer size can be adjusted
+			n, err := rand.Read(fbuffer)
+			if err != nil {
+				fmt.Println("Error reading random data:", err)
+				return
+			}
+			written += n
+			_, err = f.Write(fbuffer[:n]) // Write only the actual number of bytes read
+			if err != nil {
+				fmt.Println("Error writing to file:", err)
+				return
+			}
+		}
+		f.Close()
+	}
+
+	for _, fpath := range to_write {
+		if err := db.Put(fpath); err != nil {
+			fmt.Println(err)
+		}
+	}
+
+	debug.FreeOSMemory()
+
+	var dur_unopt time.Duration
+
+	var buffer *bytes.Buffer = bytes.NewBuffer([]byte{1})
+
+	var start_unopt time.Time
+	var end_unopt time.Time
+	for i := 0; i < n; i++ {
+		var n_dur_opt time.Duration
+		for _, fname := range to_read {
+			start_unopt = time.Now()
+			if err := db.Get(fname, buffer); err != nil {
+				continue
+			}
+			end_unopt = time.Now()
+			buffer.Reset()
+			buffer = bytes.NewBuffer([]byte{1})
+			debug.FreeOSMemory()
+			if i == 0 {
+				db.LogRead(fname)
+			}
+			n_dur_opt += end_unopt.Sub(start_unopt)
+		}
+		if i == 0 {
+			dur_unopt = n_dur_opt
+		} else {
+			dur_unopt = (n_dur_opt + dur_unopt) / 2
+		}
+		fmt.Printf("[TIME] %d: %v\n", i+1, n_dur_opt)
+	}
+
+	buffer = bytes.NewBuffer([]byte{2})
+	buffer.Reset()
+
+	debug.FreeOSMemory()
+	if mmap {
+		// the same reads served from a mapping instead of the file
+		opt_state = 0
+		if err := db.SetMmap(true); err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println("-- Memory-Mapped Reads --")
+	} else if opt_state == 1 {
+		occurance_slice := db.Occurrences()
+		print_occurance(occurance_slice)
+		if _, err := db.OptimizeLayout(occurance_slice); err != nil {
+			fmt.Println(err)
+		}
+		fmt.Println("-- Frequent-Neighbours Optimization --")
+	} else if opt_state == 2 {
+		occurance_slice := db.Occurrences()
+		print_occurance(occurance_slice)
+		db.SetPrefetch(true, occurance_slice)
+		db.ResetCache()
+		fmt.Println("-- Next-Potential-Caching Optimization --")
+	}
+
+	debug.FreeOSMemory()
+
+	var dur_opt time.Duration
+	var start_opt time.Time
+	var end_opt time.Time
+	for i := 0; i < n; i++ {
+		var n_dur_opt time.Duration
+		for _, fname := range to_read {
+			start_opt = time.Now()
+			if err := db.Get(fname, buffer); err != nil {
+				continue
+			}
+			end_opt = time.Now()
+			n_dur_opt += end_opt.Sub(start_opt)
+			if opt_state == 2 {
+				// time wait, added to simulate a real usage,
+				// where caching will have time to catch up
+				// random duration between 100ms (0.1s) and 1s
+				min := 100 * time.Millisecond
+				max := 1000 * time.Millisecond
+				randomDuration := min + time.Duration(mrand.Int63n(int64(max-min)))
+				time.Sleep(randomDuration)
+			}
+			buffer.Reset()
+			buffer = bytes.NewBuffer([]byte{2})
+			debug.FreeOSMemory()
+		}
+		cache_hits, cache_misses := db.CacheStats()
+		if i == 0 {
+			dur_opt += n_dur_opt
+			if opt_state == 2 {
+				avg_cache_hits += cache_hits
+				avg_cache_misses += cache_misses
+			}
+		} else {
+			dur_opt = (n_dur_opt + dur_opt) / 2
+			if opt_state == 2 {
+				avg_cache_hits = (avg_cache_hits + cache_hits) / 2
+				avg_cache_misses = (avg_cache_misses + cache_misses) / 2
+			}
+		}
+		db.ResetCache()
+		debug.FreeOSMemory()
+		fmt.Printf("[TIME] %d: %v\n", i+1, n_dur_opt)
+		if opt_state == 2 {
+			fmt.Printf("  Cache Hits: %d, Cache Misses: %d\n", cache_hits, cache_misses)
+		}
+	}
+
+	buffer.Reset()
+	fmt.Println("[TIME]")
+	if mmap {
+		fmt.Printf("  Seek Reads: %v\n", dur_unopt)
+		fmt.Printf("  Mmap Reads: %v\n", dur_opt)
+	} else {
+		fmt.Printf("  Before Optimization: %v\n", dur_unopt)
+		fmt.Printf("  After Optimization: %v\n", dur_opt)
+	}
+	fmt.Printf("  %d%% Faster\n", (((dur_unopt - dur_opt) * 100) / dur_unopt))
+	if opt_state == 2 {
+		fmt.Printf("  AVG. Cache Hits: %d, AVG. Cache Misses: %d\n", avg_cache_hits, avg_cache_misses)
+	}
+	err = db.ClearReadLog()
+	if err != nil {
+		fmt.Printf("[TIME] can't remove file: %v\n", err)
+		return
+	}
+	for _, fpath := range to_write {
+		err = os.Remove(fpath)
+		if err != nil {
+			fmt.Printf("[TIME] can't remove file: %v\n", err)
+			return
+		}
+	}
+	debug.FreeOSMemory()
+}